@@ -5,9 +5,11 @@ import (
 	"net/http"
 
 	"github.com/OSSystems/hulk/api/server/router"
+	"github.com/OSSystems/hulk/api/types"
 	"github.com/OSSystems/hulk/hulk"
 	"github.com/OSSystems/pkg/log"
 	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
 )
 
 type serviceRouter struct {
@@ -23,6 +25,9 @@ func Routes(hulk *hulk.Hulk) []router.Route {
 	return []router.Route{
 		{Method: "GET", Path: "/services", Handle: r.getServices},
 		{Method: "GET", Path: "/services/:service", Handle: r.getService},
+		{Method: "POST", Path: "/services/:service/enable", Handle: r.enableService},
+		{Method: "POST", Path: "/services/:service/disable", Handle: r.disableService},
+		{Method: "POST", Path: "/services/:service/reload", Handle: r.reloadService},
 	}
 }
 
@@ -55,3 +60,36 @@ func (sr *serviceRouter) getService(w http.ResponseWriter, r *http.Request, p ht
 
 	w.WriteHeader(http.StatusNotFound)
 }
+
+func (sr *serviceRouter) enableService(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sr.serviceAction(w, sr.hulk.EnableService(p.ByName("service")))
+}
+
+func (sr *serviceRouter) disableService(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sr.serviceAction(w, sr.hulk.DisableService(p.ByName("service")))
+}
+
+func (sr *serviceRouter) reloadService(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sr.serviceAction(w, sr.hulk.ReloadService(p.ByName("service")))
+}
+
+// serviceAction writes the response of a service action which returned err
+func (sr *serviceRouter) serviceAction(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err == nil {
+		return
+	}
+
+	if errors.Cause(err) == hulk.ErrServiceNotFound {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	output, _ := json.Marshal(&types.ErrorResponse{Message: err.Error()})
+
+	if _, err := w.Write(output); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OSSystems/hulk/api/server/router"
+	"github.com/OSSystems/hulk/api/types"
+	"github.com/OSSystems/hulk/hulk"
+	"github.com/OSSystems/hulk/mqtt"
+	"github.com/stretchr/testify/assert"
+)
+
+// connectedClient is an MQTT client which is always connected
+type connectedClient struct{}
+
+func (c *connectedClient) Connect() error                                        { return nil }
+func (c *connectedClient) Disconnect()                                           {}
+func (c *connectedClient) IsConnected() bool                                     { return true }
+func (c *connectedClient) Subscribe(string, byte, mqtt.MqttMessageHandler) error { return nil }
+func (c *connectedClient) Unsubscribe(string)                                    {}
+func (c *connectedClient) Publish(string, byte, bool, []byte) error              { return nil }
+
+func TestServiceActions(t *testing.T) {
+	testCases := []struct {
+		name            string
+		requests        []string
+		expectedStatus  int
+		expectedEnabled bool
+		expectedReason  string
+	}{
+		{
+			"Disable",
+			[]string{"/services/devices/disable"},
+			http.StatusOK,
+			false,
+			types.StatusReasonAdminDisabled,
+		},
+
+		{
+			"Enable",
+			[]string{"/services/devices/disable", "/services/devices/enable"},
+			http.StatusOK,
+			true,
+			"",
+		},
+
+		{
+			"Reload",
+			[]string{"/services/devices/reload"},
+			http.StatusOK,
+			true,
+			"",
+		},
+
+		{
+			"ReloadDisabled",
+			[]string{"/services/devices/disable", "/services/devices/reload"},
+			http.StatusOK,
+			false,
+			types.StatusReasonAdminDisabled,
+		},
+
+		{
+			"NotFound",
+			[]string{"/services/missing/disable"},
+			http.StatusNotFound,
+			true,
+			"",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := hulk.NewHulk(&connectedClient{}, "")
+			assert.NoError(t, err)
+
+			assert.NoError(t, h.AddService("devices", hulk.Manifest{Topics: []string{"devices/+"}}))
+			assert.NoError(t, h.LoadServices())
+
+			r := router.NewRouter(Routes(h))
+
+			var w *httptest.ResponseRecorder
+
+			for _, path := range tc.requests {
+				w = httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+			}
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+
+			if tc.expectedStatus != http.StatusOK {
+				response := types.ErrorResponse{}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, hulk.ErrServiceNotFound.Error(), response.Message)
+			}
+
+			service := h.Services()[0]
+			assert.Equal(t, tc.expectedEnabled, service.Enabled)
+
+			if tc.expectedReason == "" {
+				assert.Nil(t, service.Status)
+			} else {
+				assert.Equal(t, tc.expectedReason, service.Status.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+package types
+
+// ErrorResponse contains the error response of Hulk API
+type ErrorResponse struct {
+	Message string `json:"Message"`
+}
@@ -1,12 +1,133 @@
 package types
 
+import "time"
+
 // Service contains response of Hulk API: GET /services
 type Service struct {
-	Name        string   `json:"Name" yaml:"Name"`
-	Description string   `json:"Description" yaml:"Description"`
-	Enabled     bool     `json:"Enabled" yaml:"Enabled"`
-	Topics      []string `json:"Topics" yaml:"Topics"`
+	Name        string         `json:"Name" yaml:"Name"`
+	Description string         `json:"Description" yaml:"Description"`
+	Enabled     bool           `json:"Enabled" yaml:"Enabled"`
+	Status      *ServiceStatus `json:"Status,omitempty" yaml:"Status,omitempty"`
+	Requires    []string       `json:"Requires,omitempty" yaml:"Requires,omitempty"`
+	After       []string       `json:"After,omitempty" yaml:"After,omitempty"`
+	Topics      []string       `json:"Topics" yaml:"Topics"`
+	Variables   []string       `json:"Variables,omitempty" yaml:"Variables,omitempty"`
+	Filter      string         `json:"Filter,omitempty" yaml:"Filter,omitempty"`
 	Hooks       struct {
-		OnReceive string `json:"OnReceive" yaml:"OnReceive"`
+		OnReceive     []*HookRule `json:"OnReceive" yaml:"OnReceive"`
+		OnReceiveMode string      `json:"OnReceiveMode" yaml:"OnReceiveMode"`
 	} `json:"Hooks" yaml:"Hooks"`
+	Environment map[string]string `json:"Environment" yaml:"Environment"`
+	Process     *ProcessOptions   `json:"Process,omitempty" yaml:"Process,omitempty"`
+	History     []*Execution      `json:"History" yaml:"History"`
+}
+
+// Reasons why a service is disabled
+const (
+	// StatusReasonManifestError means the service manifest could not be loaded
+	StatusReasonManifestError = "ManifestError"
+	// StatusReasonDependencyCycle means the service is part of a dependency cycle
+	StatusReasonDependencyCycle = "DependencyCycle"
+	// StatusReasonAdminDisabled means the service was disabled through the API
+	StatusReasonAdminDisabled = "AdminDisabled"
+	// StatusReasonRequiredService means a service required by the service is not enabled
+	StatusReasonRequiredService = "RequiredService"
+	// StatusReasonBrokerDisconnected means the broker was disconnected when the service was loaded or reloaded
+	StatusReasonBrokerDisconnected = "BrokerDisconnected"
+	// StatusReasonMissingVariable means a topic has a required variable without value
+	StatusReasonMissingVariable = "MissingVariable"
+	// StatusReasonInvalidTopic means a topic could not be expanded or its captures are invalid
+	StatusReasonInvalidTopic = "InvalidTopic"
+	// StatusReasonEnvironmentFileRemoved means an environment file of the service was removed
+	StatusReasonEnvironmentFileRemoved = "EnvironmentFileRemoved"
+)
+
+// ServiceStatus contains the reason why a service is disabled
+//
+// Variable and Topic are set for missing variables, Topic for invalid topics, Service for required services,
+// File for removed environment files and File, Line and Column for manifest errors,
+// Line and Column are zero if unknown.
+type ServiceStatus struct {
+	Reason   string `json:"Reason" yaml:"Reason"`
+	Message  string `json:"Message" yaml:"Message"`
+	Variable string `json:"Variable,omitempty" yaml:"Variable,omitempty"`
+	Topic    string `json:"Topic,omitempty" yaml:"Topic,omitempty"`
+	Service  string `json:"Service,omitempty" yaml:"Service,omitempty"`
+	File     string `json:"File,omitempty" yaml:"File,omitempty"`
+	Line     int    `json:"Line,omitempty" yaml:"Line,omitempty"`
+	Column   int    `json:"Column,omitempty" yaml:"Column,omitempty"`
+}
+
+// HookRule contains a hook rule of a service
+type HookRule struct {
+	Name        string            `json:"Name,omitempty" yaml:"Name,omitempty"`
+	Topic       string            `json:"Topic,omitempty" yaml:"Topic,omitempty"`
+	Filter      string            `json:"Filter,omitempty" yaml:"Filter,omitempty"`
+	Command     string            `json:"Command,omitempty" yaml:"Command,omitempty"`
+	Exec        []string          `json:"Exec,omitempty" yaml:"Exec,omitempty"`
+	HTTP        *HTTPAction       `json:"HTTP,omitempty" yaml:"HTTP,omitempty"`
+	File        *FileAction       `json:"File,omitempty" yaml:"File,omitempty"`
+	Publish     *PublishAction    `json:"Publish,omitempty" yaml:"Publish,omitempty"`
+	Log         *LogAction        `json:"Log,omitempty" yaml:"Log,omitempty"`
+	Handler     string            `json:"Handler,omitempty" yaml:"Handler,omitempty"`
+	Timeout     string            `json:"Timeout,omitempty" yaml:"Timeout,omitempty"`
+	Retries     int               `json:"Retries,omitempty" yaml:"Retries,omitempty"`
+	RetryDelay  string            `json:"RetryDelay,omitempty" yaml:"RetryDelay,omitempty"`
+	Payload     string            `json:"Payload,omitempty" yaml:"Payload,omitempty"`
+	Environment map[string]string `json:"Environment,omitempty" yaml:"Environment,omitempty"`
+	Process     *ProcessOptions   `json:"Process,omitempty" yaml:"Process,omitempty"`
+}
+
+// HTTPAction contains the built-in HTTP request action of a hook rule
+type HTTPAction struct {
+	Method  string            `json:"Method,omitempty" yaml:"Method,omitempty"`
+	URL     string            `json:"URL" yaml:"URL"`
+	Headers map[string]string `json:"Headers,omitempty" yaml:"Headers,omitempty"`
+	Body    string            `json:"Body,omitempty" yaml:"Body,omitempty"`
+}
+
+// FileAction contains the built-in file write action of a hook rule
+type FileAction struct {
+	Path   string `json:"Path" yaml:"Path"`
+	Append bool   `json:"Append,omitempty" yaml:"Append,omitempty"`
+	Mode   string `json:"Mode,omitempty" yaml:"Mode,omitempty"`
+}
+
+// PublishAction contains the built-in publish action of a hook rule
+type PublishAction struct {
+	Topic   string `json:"Topic" yaml:"Topic"`
+	Payload string `json:"Payload,omitempty" yaml:"Payload,omitempty"`
+	QoS     byte   `json:"QoS,omitempty" yaml:"QoS,omitempty"`
+	Retain  bool   `json:"Retain,omitempty" yaml:"Retain,omitempty"`
+}
+
+// LogAction contains the built-in log action of a hook rule
+type LogAction struct {
+	Level   string `json:"Level,omitempty" yaml:"Level,omitempty"`
+	Message string `json:"Message" yaml:"Message"`
+}
+
+// ProcessOptions contains the process attributes of hook commands
+type ProcessOptions struct {
+	User             string `json:"User,omitempty" yaml:"User,omitempty"`
+	Group            string `json:"Group,omitempty" yaml:"Group,omitempty"`
+	WorkingDirectory string `json:"WorkingDirectory,omitempty" yaml:"WorkingDirectory,omitempty"`
+	Umask            string `json:"Umask,omitempty" yaml:"Umask,omitempty"`
+	Nice             int    `json:"Nice,omitempty" yaml:"Nice,omitempty"`
+	Limits           struct {
+		CPU          uint64 `json:"CPU,omitempty" yaml:"CPU,omitempty"`
+		AddressSpace uint64 `json:"AddressSpace,omitempty" yaml:"AddressSpace,omitempty"`
+		OpenFiles    uint64 `json:"OpenFiles,omitempty" yaml:"OpenFiles,omitempty"`
+	} `json:"Limits" yaml:"Limits"`
+}
+
+// Execution contains a message handled by a service
+type Execution struct {
+	Time    time.Time `json:"Time" yaml:"Time"`
+	Topic   string    `json:"Topic" yaml:"Topic"`
+	Hook    string    `json:"Hook" yaml:"Hook"`
+	Rule    string    `json:"Rule,omitempty" yaml:"Rule,omitempty"`
+	Filter  string    `json:"Filter,omitempty" yaml:"Filter,omitempty"`
+	Running bool      `json:"Running,omitempty" yaml:"Running,omitempty"`
+	Error   string    `json:"Error,omitempty" yaml:"Error,omitempty"`
 }
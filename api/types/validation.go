@@ -0,0 +1,15 @@
+package types
+
+// ValidationResult contains the result of validating a manifest file
+type ValidationResult struct {
+	File   string             `json:"File" yaml:"File"`
+	Valid  bool               `json:"Valid" yaml:"Valid"`
+	Errors []*ValidationError `json:"Errors" yaml:"Errors"`
+}
+
+// ValidationError contains an error found in a manifest file, Line and Column are zero if unknown
+type ValidationError struct {
+	Line    int    `json:"Line,omitempty" yaml:"Line,omitempty"`
+	Column  int    `json:"Column,omitempty" yaml:"Column,omitempty"`
+	Message string `json:"Message" yaml:"Message"`
+}
@@ -83,6 +83,15 @@ func (cli *Client) sendRequest(method, path string, body io.Reader) (*http.Respo
 	if err != nil {
 		return nil, err
 	} else if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+
+		var errResp types.ErrorResponse
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(body, &errResp) == nil && errResp.Message != "" {
+			return nil, errors.New(errResp.Message)
+		}
+
 		return nil, fmt.Errorf("error status code %d", resp.StatusCode)
 	}
 
@@ -130,3 +139,27 @@ func (cli *Client) GetService(name string) (*types.Service, error) {
 
 	return service, err
 }
+
+// EnableService enables an administratively disabled service in Hulk Daemon
+func (cli *Client) EnableService(name string) error {
+	return cli.serviceAction(name, "enable")
+}
+
+// DisableService administratively disables a service in Hulk Daemon
+func (cli *Client) DisableService(name string) error {
+	return cli.serviceAction(name, "disable")
+}
+
+// ReloadService reloads a service manifest and environment in Hulk Daemon
+func (cli *Client) ReloadService(name string) error {
+	return cli.serviceAction(name, "reload")
+}
+
+func (cli *Client) serviceAction(name, action string) error {
+	resp, err := cli.sendRequest("POST", fmt.Sprintf("/services/%s/%s", name, action), nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/fatih/color"
@@ -9,7 +12,9 @@ import (
 	"github.com/zyedidia/highlight"
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/OSSystems/hulk/api/types"
 	"github.com/OSSystems/hulk/client"
+	"github.com/OSSystems/hulk/hulk"
 )
 
 // Text formatting
@@ -24,16 +29,26 @@ func ListServices(cli *client.Client) error {
 	}
 
 	table := uitable.New()
-	table.AddRow("SERVICE", "STATUS", "DESCRIPTION")
+	table.AddRow("SERVICE", "STATUS", "REASON", "DESCRIPTION")
 
 	for _, service := range services {
 		status := "enabled"
+		reason := ""
 
 		if !service.Enabled {
 			status = "disabled"
 		}
 
-		table.AddRow(service.Name, status, service.Description)
+		if service.Status != nil {
+			reason = service.Status.Message
+
+			// The manifest file was dropped
+			if service.Status.Reason == types.StatusReasonManifestError {
+				status = "broken"
+			}
+		}
+
+		table.AddRow(service.Name, status, reason, service.Description)
 	}
 
 	fmt.Println(table)
@@ -42,6 +57,79 @@ func ListServices(cli *client.Client) error {
 	return nil
 }
 
+func EnableService(cli *client.Client, name string) error {
+	if err := cli.EnableService(name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Service %s enabled.\n", name)
+
+	return nil
+}
+
+func DisableService(cli *client.Client, name string) error {
+	if err := cli.DisableService(name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Service %s disabled.\n", name)
+
+	return nil
+}
+
+func ReloadService(cli *client.Client, name string) error {
+	if err := cli.ReloadService(name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Service %s reloaded.\n", name)
+
+	return nil
+}
+
+// errInvalidManifests is returned by Validate when a manifest is invalid
+var errInvalidManifests = errors.New("invalid manifests")
+
+// Validate validates manifest files, the rules may use the handlers, which are registered
+// by the programs embedding Hulk and are unknown otherwise
+func Validate(files []string, output string, handlers []string) error {
+	if output != "text" && output != "json" {
+		return fmt.Errorf("invalid output format: %s", output)
+	}
+
+	h, err := hulk.NewHulk(nil, "")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range handlers {
+		handler := hulk.HandlerFunc(func(ctx context.Context, msg *hulk.Message) error {
+			return nil
+		})
+
+		if err := h.RegisterHandler(name, handler); err != nil {
+			return err
+		}
+	}
+
+	results := h.ValidateManifests(files...)
+
+	invalid, err := hulk.WriteValidationResults(os.Stdout, results, output == "json")
+	if err != nil {
+		return err
+	}
+
+	if output == "text" {
+		fmt.Printf(Bold("\n%d of %d manifests are invalid.\n"), invalid, len(results))
+	}
+
+	if invalid > 0 {
+		return errInvalidManifests
+	}
+
+	return nil
+}
+
 func InspectService(cli *client.Client, name string) error {
 	service, err := cli.GetService(name)
 	if err != nil {
@@ -10,7 +10,9 @@ import (
 )
 
 var (
-	hulkAddress = "unix:///var/run/hulkd.sock"
+	hulkAddress  = "unix:///var/run/hulkd.sock"
+	outputFormat = "text"
+	handlers     = []string{}
 )
 
 var cli *client.Client
@@ -52,6 +54,72 @@ func main() {
 		},
 	})
 
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "enable [NAME]",
+		Short: "Enable Hulk service",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			initClient()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("Service name is missing")
+			}
+
+			return EnableService(cli, args[0])
+		},
+	})
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "disable [NAME]",
+		Short: "Disable Hulk service",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			initClient()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("Service name is missing")
+			}
+
+			return DisableService(cli, args[0])
+		},
+	})
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "reload [NAME]",
+		Short: "Reload Hulk service",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			initClient()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("Service name is missing")
+			}
+
+			return ReloadService(cli, args[0])
+		},
+	})
+
+	validateCmd := &cobra.Command{
+		Use:   "validate FILE...",
+		Short: "Validate service manifest files offline",
+		// Validation runs offline, without connecting to Hulk Daemon
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("Manifest file is missing")
+			}
+
+			return Validate(args, outputFormat, handlers)
+		},
+		// The validation errors are already reported
+		SilenceUsage: true,
+	}
+
+	validateCmd.Flags().StringVarP(&outputFormat, "output", "o", outputFormat, "Output format (text|json)")
+	validateCmd.Flags().StringSliceVarP(&handlers, "handler", "H", handlers, "Name of a handler available to the hook rules, may be repeated")
+
+	rootCmd.AddCommand(validateCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
@@ -10,9 +10,10 @@ import (
 	"github.com/OSSystems/hulk/api/server/router"
 	"github.com/OSSystems/hulk/api/server/router/service"
 	"github.com/OSSystems/hulk/hulk"
-	"github.com/OSSystems/pkg/log"
 	"github.com/OSSystems/hulk/mqtt"
 	"github.com/OSSystems/hulk/pkg/filewatcher"
+	"github.com/OSSystems/hulk/pkg/secret"
+	"github.com/OSSystems/pkg/log"
 	"github.com/Sirupsen/logrus"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"github.com/joho/godotenv"
@@ -20,11 +21,17 @@ import (
 )
 
 var (
-	servicesDir   = "/etc/hulk.d/"
-	brokerAddress = "tcp://localhost:1883"
-	listenAddress = "unix:///var/run/hulkd.sock"
-	authFile      = ""
-	logLevel      = "info"
+	servicesDir    = "/etc/hulk.d/"
+	brokerAddress  = "tcp://localhost:1883"
+	listenAddress  = "unix:///var/run/hulkd.sock"
+	authFile       = ""
+	envFile        = ""
+	stateFile      = ""
+	secretPatterns []string
+	logLevel       = "info"
+	check          = false
+	outputFormat   = "text"
+	debounce       = filewatcher.DefaultDebounce
 )
 
 var RootCmd = &cobra.Command{
@@ -48,6 +55,10 @@ var RootCmd = &cobra.Command{
 			log.SetLevel(logrus.InfoLevel)
 		}
 
+		if check {
+			checkServices()
+		}
+
 		client := newMqttClient()
 
 		connectToBroker(client)
@@ -57,6 +68,21 @@ var RootCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
+		hulk.SetSecretPatterns(secretPatterns...)
+		hulk.SetDebounce(debounce)
+
+		if stateFile != "" {
+			if err := hulk.SetStateFile(stateFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if envFile != "" {
+			if err := hulk.SetEnvironmentFile(envFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+
 		if err := hulk.LoadServices(); err != nil {
 			log.Fatal(err)
 		}
@@ -93,6 +119,12 @@ func main() {
 	RootCmd.PersistentFlags().StringVarP(&brokerAddress, "broker", "b", brokerAddress, "Broker address to connect")
 	RootCmd.PersistentFlags().StringVarP(&listenAddress, "listen", "l", listenAddress, "API server listen address")
 	RootCmd.PersistentFlags().StringVarP(&authFile, "auth", "a", authFile, "Authentication file")
+	RootCmd.PersistentFlags().StringVarP(&envFile, "env-file", "e", envFile, "Default environment file for all services")
+	RootCmd.PersistentFlags().StringVarP(&stateFile, "state-file", "S", stateFile, "File where administratively disabled services are persisted, they are only kept in memory if empty")
+	RootCmd.PersistentFlags().DurationVarP(&debounce, "debounce", "D", debounce, "Time without changes to wait before reloading a changed environment or auth file")
+	RootCmd.PersistentFlags().StringSliceVarP(&secretPatterns, "secret-pattern", "s", secretPatterns, "Name pattern of secret variables masked in logs and API output")
+	RootCmd.PersistentFlags().BoolVarP(&check, "check", "c", check, "Validate the service manifests and exit")
+	RootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputFormat, "Output format of --check (text|json)")
 	RootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "L", logLevel, "Set the logging level (panic|fatal|error|warn|info|debug)")
 
 	if err := RootCmd.Execute(); err != nil {
@@ -101,6 +133,30 @@ func main() {
 	}
 }
 
+// checkServices validates the service manifests and exits with non-zero status if any is invalid
+func checkServices() {
+	h, err := hulk.NewHulk(nil, servicesDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results, err := h.Check()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	invalid, err := hulk.WriteValidationResults(os.Stdout, results, outputFormat == "json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if invalid > 0 {
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
 func newMqttClient() mqtt.MqttClient {
 	opts := MQTT.NewClientOptions()
 	opts.AddBroker(brokerAddress)
@@ -108,9 +164,11 @@ func newMqttClient() mqtt.MqttClient {
 	if _, err := os.Stat(authFile); err == nil {
 		auth, err := godotenv.Read(authFile)
 		if err == nil {
+			masker := secret.NewMasker(secret.DefaultPatterns...).With(secretPatterns...)
+
 			log.WithFields(logrus.Fields{
 				"file": authFile,
-				"auth": auth,
+				"auth": masker.Environment(auth),
 			}).Debug("new authorization")
 
 			if id, ok := auth["HULK_ID"]; ok {
@@ -147,7 +205,7 @@ func watchAuthFile(hulk *hulk.Hulk) {
 			log.WithFields(logrus.Fields{"file": authFile}).Warn("auth file does not exist")
 		}
 
-		if err := authWatcher.Add(authFile); err != nil {
+		if err := authWatcher.AddDebounced(authFile, debounce); err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -157,8 +215,17 @@ func watchAuthFile(hulk *hulk.Hulk) {
 	go func() {
 		for {
 			select {
-			case <-authWatcher.Changed:
-				log.WithFields(logrus.Fields{"file": authFile}).Debug("auth file changed")
+			case event := <-authWatcher.Events:
+				log.WithFields(logrus.Fields{
+					"file":  authFile,
+					"event": event.Type,
+				}).Debug("auth file changed")
+
+				// Keep the current credentials until the file is created again
+				if event.Type == filewatcher.Removed {
+					log.WithFields(logrus.Fields{"file": authFile}).Warn("auth file removed, keeping the current connection")
+					continue
+				}
 
 				client := newMqttClient()
 				connectToBroker(client)
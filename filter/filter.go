@@ -0,0 +1,329 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError implements an error returned when compiling a filter expression
+type SyntaxError struct {
+	Position int
+	Message  string
+}
+
+// Error returns a string representation of an SyntaxError
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("filter syntax error at position %d: %s", e.Position, e.Message)
+}
+
+// Filter is a compiled filter expression
+type Filter struct {
+	expression string
+	root       node
+}
+
+// Compile parses a filter expression
+//
+// The expression language supports:
+//   topic              the full topic of the message
+//   topic[N]           the topic segment N (negative indexes count from the end)
+//   payload            the raw payload of the message
+//   payload.a.b[N]     a field of a JSON payload
+//   "str", 1.5, true, false, null
+//   == != < <= > >=    comparisons
+//   =~ !~              regular expression match
+//   && || ! ( )        boolean combinations
+func Compile(expression string) (*Filter, error) {
+	l := &lexer{input: expression}
+	if err := l.run(); err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: l.tokens}
+
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{expression: expression, root: root}, nil
+}
+
+// Match evaluates the filter against a message
+func (f *Filter) Match(topic string, payload []byte) bool {
+	msg := &message{
+		topic:    topic,
+		segments: strings.Split(topic, "/"),
+		payload:  payload,
+	}
+
+	return truthy(f.root.eval(msg))
+}
+
+// String returns the source expression of the filter
+func (f *Filter) String() string {
+	return f.expression
+}
+
+type message struct {
+	topic    string
+	segments []string
+	payload  []byte
+	decoded  bool
+	json     interface{}
+}
+
+func (m *message) document() interface{} {
+	if !m.decoded {
+		m.decoded = true
+
+		if err := json.Unmarshal(m.payload, &m.json); err != nil {
+			m.json = nil
+		}
+	}
+
+	return m.json
+}
+
+type node interface {
+	eval(msg *message) interface{}
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) eval(msg *message) interface{} {
+	return truthy(n.left.eval(msg)) || truthy(n.right.eval(msg))
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) eval(msg *message) interface{} {
+	return truthy(n.left.eval(msg)) && truthy(n.right.eval(msg))
+}
+
+type notNode struct {
+	node node
+}
+
+func (n *notNode) eval(msg *message) interface{} {
+	return !truthy(n.node.eval(msg))
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(msg *message) interface{} {
+	return n.value
+}
+
+type topicNode struct {
+	segment bool
+	index   int
+}
+
+func (n *topicNode) eval(msg *message) interface{} {
+	if !n.segment {
+		return msg.topic
+	}
+
+	index := n.index
+	if index < 0 {
+		index += len(msg.segments)
+	}
+
+	if index < 0 || index >= len(msg.segments) {
+		return nil
+	}
+
+	return msg.segments[index]
+}
+
+type pathElement struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+type payloadNode struct {
+	path []pathElement
+}
+
+func (n *payloadNode) eval(msg *message) interface{} {
+	if len(n.path) == 0 {
+		return string(msg.payload)
+	}
+
+	value := msg.document()
+
+	for _, elem := range n.path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if elem.isIndex {
+				return nil
+			}
+
+			value = v[elem.key]
+		case []interface{}:
+			index := elem.index
+			if !elem.isIndex {
+				var err error
+				if index, err = strconv.Atoi(elem.key); err != nil {
+					return nil
+				}
+			}
+
+			if index < 0 {
+				index += len(v)
+			}
+
+			if index < 0 || index >= len(v) {
+				return nil
+			}
+
+			value = v[index]
+		default:
+			return nil
+		}
+	}
+
+	return value
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+	re          *regexp.Regexp
+}
+
+func (n *compareNode) eval(msg *message) interface{} {
+	left := n.left.eval(msg)
+
+	switch n.op {
+	case "=~":
+		return left != nil && n.re.MatchString(toString(left))
+	case "!~":
+		return left == nil || !n.re.MatchString(toString(left))
+	}
+
+	right := n.right.eval(msg)
+
+	switch n.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	}
+
+	if left == nil || right == nil {
+		return false
+	}
+
+	cmp := compare(left, right)
+
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+
+	return false
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	}
+
+	return true
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	data, _ := json.Marshal(value)
+
+	return string(data)
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+func isNumber(value interface{}) bool {
+	_, ok := value.(float64)
+	return ok
+}
+
+func equal(left, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+
+	// Compare numerically when one of the sides is a number,
+	// so topic segments like "42" match the literal 42
+	if isNumber(left) || isNumber(right) {
+		l, lok := toNumber(left)
+		r, rok := toNumber(right)
+
+		if lok && rok {
+			return l == r
+		}
+	}
+
+	return toString(left) == toString(right)
+}
+
+func compare(left, right interface{}) int {
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+
+	if !lok || !rok {
+		return strings.Compare(toString(left), toString(right))
+	}
+
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+
+	return 0
+}
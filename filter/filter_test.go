@@ -0,0 +1,198 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		name           string
+		expression     string
+		topic          string
+		payload        string
+		expectedResult bool
+	}{
+		{
+			"TopicEqual",
+			`topic == "devices/1/reboot"`,
+			"devices/1/reboot",
+			"",
+			true,
+		},
+
+		{
+			"TopicSegment",
+			`topic[2] == "reboot"`,
+			"devices/1/reboot",
+			"",
+			true,
+		},
+
+		{
+			"TopicLastSegment",
+			`topic[-1] == "update"`,
+			"devices/1/reboot",
+			"",
+			false,
+		},
+
+		{
+			"TopicSegmentOutOfRange",
+			`topic[5] == "reboot"`,
+			"devices/1/reboot",
+			"",
+			false,
+		},
+
+		{
+			"TopicSegmentNumber",
+			`topic[1] == 1`,
+			"devices/1/reboot",
+			"",
+			true,
+		},
+
+		{
+			"TopicRegexp",
+			`topic =~ "^devices/[0-9]+/"`,
+			"devices/1/reboot",
+			"",
+			true,
+		},
+
+		{
+			"TopicNotRegexp",
+			`topic !~ "^devices/"`,
+			"devices/1/reboot",
+			"",
+			false,
+		},
+
+		{
+			"PayloadField",
+			`payload.version >= 2`,
+			"devices/1/update",
+			`{"version": 3}`,
+			true,
+		},
+
+		{
+			"PayloadNestedField",
+			`payload.image.name == "rootfs"`,
+			"devices/1/update",
+			`{"image": {"name": "rootfs"}}`,
+			true,
+		},
+
+		{
+			"PayloadArrayIndex",
+			`payload.tags[-1] == "beta"`,
+			"devices/1/update",
+			`{"tags": ["stable", "beta"]}`,
+			true,
+		},
+
+		{
+			"PayloadMissingField",
+			`payload.version > 1`,
+			"devices/1/update",
+			`{}`,
+			false,
+		},
+
+		{
+			"PayloadNotJSON",
+			`payload.version == null`,
+			"devices/1/update",
+			`not json`,
+			true,
+		},
+
+		{
+			"RawPayload",
+			`payload == "now"`,
+			"devices/1/reboot",
+			`now`,
+			true,
+		},
+
+		{
+			"BooleanCombination",
+			`topic[2] == "update" && (payload.force || payload.version > 2)`,
+			"devices/1/update",
+			`{"force": false, "version": 3}`,
+			true,
+		},
+
+		{
+			"Negation",
+			`!(topic[2] == "reboot")`,
+			"devices/1/reboot",
+			"",
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := Compile(tc.expression)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedResult, f.Match(tc.topic, []byte(tc.payload)))
+		})
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	testCases := []struct {
+		name          string
+		expression    string
+		expectedError string
+	}{
+		{
+			"UnknownIdentifier",
+			`device == "1"`,
+			`filter syntax error at position 0: unknown identifier "device"`,
+		},
+
+		{
+			"UnterminatedString",
+			`topic == "devices`,
+			"filter syntax error at position 9: unterminated string",
+		},
+
+		{
+			"MissingParen",
+			`(topic == "a"`,
+			"filter syntax error at position 13: expected ')'",
+		},
+
+		{
+			"InvalidRegexp",
+			`topic =~ "("`,
+			"filter syntax error at position 6: error parsing regexp: missing closing ): `(`",
+		},
+
+		{
+			"RegexpWithoutString",
+			`topic =~ 1`,
+			"filter syntax error at position 6: right side of regexp match must be a string",
+		},
+
+		{
+			"TrailingTokens",
+			`topic topic`,
+			`filter syntax error at position 6: unexpected "topic"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.expression)
+
+			assert.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
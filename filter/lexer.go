@@ -0,0 +1,154 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBracket
+	tokenRightBracket
+	tokenDot
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// operators sorted by length so the longest operator always wins
+var operators = []string{"==", "!=", "=~", "!~", "<=", ">=", "&&", "||", "<", ">", "!", "-"}
+
+type lexer struct {
+	input  string
+	pos    int
+	tokens []token
+}
+
+func (l *lexer) run() error {
+	for {
+		l.skipSpaces()
+
+		if l.pos >= len(l.input) {
+			l.emit(tokenEOF, "", l.pos)
+			return nil
+		}
+
+		start := l.pos
+		c := l.input[l.pos]
+
+		switch {
+		case c == '(':
+			l.pos++
+			l.emit(tokenLeftParen, "(", start)
+		case c == ')':
+			l.pos++
+			l.emit(tokenRightParen, ")", start)
+		case c == '[':
+			l.pos++
+			l.emit(tokenLeftBracket, "[", start)
+		case c == ']':
+			l.pos++
+			l.emit(tokenRightBracket, "]", start)
+		case c == '.':
+			l.pos++
+			l.emit(tokenDot, ".", start)
+		case c == '"' || c == '\'':
+			value, err := l.scanString(c)
+			if err != nil {
+				return err
+			}
+
+			l.emit(tokenString, value, start)
+		case c >= '0' && c <= '9':
+			l.emit(tokenNumber, l.scanWhile(isNumberChar), start)
+		case c == '_' || unicode.IsLetter(rune(c)):
+			l.emit(tokenIdent, l.scanWhile(isIdentChar), start)
+		default:
+			op := l.scanOperator()
+			if op == "" {
+				return &SyntaxError{Position: start, Message: fmt.Sprintf("unexpected character %q", c)}
+			}
+
+			l.emit(tokenOperator, op, start)
+		}
+	}
+}
+
+func (l *lexer) emit(kind tokenKind, value string, pos int) {
+	l.tokens = append(l.tokens, token{kind: kind, value: value, pos: pos})
+}
+
+func (l *lexer) skipSpaces() {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+}
+
+func (l *lexer) scanWhile(accept func(byte) bool) string {
+	start := l.pos
+
+	for l.pos < len(l.input) && accept(l.input[l.pos]) {
+		l.pos++
+	}
+
+	return l.input[start:l.pos]
+}
+
+func (l *lexer) scanOperator() string {
+	for _, op := range operators {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			return op
+		}
+	}
+
+	return ""
+}
+
+func (l *lexer) scanString(quote byte) (string, error) {
+	start := l.pos
+	value := []byte{}
+
+	// Skip opening quote
+	l.pos++
+
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+
+		switch c {
+		case quote:
+			l.pos++
+			return string(value), nil
+		case '\\':
+			if l.pos+1 < len(l.input) {
+				l.pos++
+				c = l.input[l.pos]
+			}
+		}
+
+		value = append(value, c)
+		l.pos++
+	}
+
+	return "", &SyntaxError{Position: start, Message: "unterminated string"}
+}
+
+func isNumberChar(c byte) bool {
+	return (c >= '0' && c <= '9') || c == '.'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || unicode.IsLetter(rune(c))
+}
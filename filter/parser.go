@@ -0,0 +1,298 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) parse() (node, error) {
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.unexpected(tok)
+	}
+
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *parser) acceptOperator(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokenOperator {
+		return "", false
+	}
+
+	for _, op := range ops {
+		if tok.value == op {
+			p.next()
+			return op, true
+		}
+	}
+
+	return "", false
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, &SyntaxError{Position: tok.pos, Message: fmt.Sprintf("expected %s", what)}
+	}
+
+	return tok, nil
+}
+
+func (p *parser) unexpected(tok token) error {
+	if tok.kind == tokenEOF {
+		return &SyntaxError{Position: tok.pos, Message: "unexpected end of expression"}
+	}
+
+	return &SyntaxError{Position: tok.pos, Message: fmt.Sprintf("unexpected %q", tok.value)}
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.acceptOperator("||"); !ok {
+			return left, nil
+		}
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &orNode{left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.acceptOperator("&&"); !ok {
+			return left, nil
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &andNode{left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if _, ok := p.acceptOperator("!"); ok {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &notNode{node: n}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+
+	op, ok := p.acceptOperator("==", "!=", "=~", "!~", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &compareNode{op: op, left: left, right: right}
+
+	if op == "=~" || op == "!~" {
+		lit, ok := right.(*literalNode)
+		if !ok {
+			return nil, &SyntaxError{Position: tok.pos, Message: "right side of regexp match must be a string"}
+		}
+
+		pattern, ok := lit.value.(string)
+		if !ok {
+			return nil, &SyntaxError{Position: tok.pos, Message: "right side of regexp match must be a string"}
+		}
+
+		n.re, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, &SyntaxError{Position: tok.pos, Message: err.Error()}
+		}
+	}
+
+	return n, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenLeftParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expect(tokenRightParen, "')'"); err != nil {
+			return nil, err
+		}
+
+		return n, nil
+	case tokenString:
+		return &literalNode{value: tok.value}, nil
+	case tokenNumber:
+		return p.parseNumber(tok, false)
+	case tokenOperator:
+		if tok.value == "-" {
+			num, err := p.expect(tokenNumber, "number")
+			if err != nil {
+				return nil, err
+			}
+
+			return p.parseNumber(num, true)
+		}
+	case tokenIdent:
+		switch tok.value {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		case "topic":
+			return p.parseTopic()
+		case "payload":
+			return p.parsePayload()
+		}
+
+		return nil, &SyntaxError{Position: tok.pos, Message: fmt.Sprintf("unknown identifier %q", tok.value)}
+	}
+
+	return nil, p.unexpected(tok)
+}
+
+func (p *parser) parseNumber(tok token, negative bool) (node, error) {
+	value, err := strconv.ParseFloat(tok.value, 64)
+	if err != nil {
+		return nil, &SyntaxError{Position: tok.pos, Message: fmt.Sprintf("invalid number %q", tok.value)}
+	}
+
+	if negative {
+		value = -value
+	}
+
+	return &literalNode{value: value}, nil
+}
+
+func (p *parser) parseIndex() (int, error) {
+	negative := false
+
+	if _, ok := p.acceptOperator("-"); ok {
+		negative = true
+	}
+
+	tok, err := p.expect(tokenNumber, "index")
+	if err != nil {
+		return 0, err
+	}
+
+	index, err := strconv.Atoi(tok.value)
+	if err != nil {
+		return 0, &SyntaxError{Position: tok.pos, Message: fmt.Sprintf("invalid index %q", tok.value)}
+	}
+
+	if _, err := p.expect(tokenRightBracket, "']'"); err != nil {
+		return 0, err
+	}
+
+	if negative {
+		index = -index
+	}
+
+	return index, nil
+}
+
+func (p *parser) parseTopic() (node, error) {
+	if p.peek().kind != tokenLeftBracket {
+		return &topicNode{}, nil
+	}
+
+	p.next()
+
+	index, err := p.parseIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return &topicNode{segment: true, index: index}, nil
+}
+
+func (p *parser) parsePayload() (node, error) {
+	n := &payloadNode{}
+
+	for {
+		switch p.peek().kind {
+		case tokenDot:
+			p.next()
+
+			tok := p.next()
+			if tok.kind != tokenIdent && tok.kind != tokenString && tok.kind != tokenNumber {
+				return nil, &SyntaxError{Position: tok.pos, Message: "expected field name"}
+			}
+
+			n.path = append(n.path, pathElement{key: tok.value})
+		case tokenLeftBracket:
+			p.next()
+
+			index, err := p.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+
+			n.path = append(n.path, pathElement{index: index, isIndex: true})
+		default:
+			return n, nil
+		}
+	}
+}
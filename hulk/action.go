@@ -0,0 +1,418 @@
+package hulk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/OSSystems/hulk/template"
+	"github.com/OSSystems/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// action is the work done by a hook
+type action interface {
+	// name returns the action type name
+	name() string
+	// run runs the action once, it must return as soon as ctx is done
+	run(ctx context.Context, h *Hook, variables map[string]string, payload []byte) error
+}
+
+// action returns the action of the hook rule or nil if the rule has no action
+func (h *Hook) action() action {
+	switch {
+	case h.rule.Command != "" || len(h.rule.Exec) > 0:
+		return &execAction{}
+	case h.rule.HTTP != nil:
+		return &httpAction{h.rule.HTTP}
+	case h.rule.File != nil:
+		return &fileAction{h.rule.File}
+	case h.rule.Publish != nil:
+		return &publishAction{h.rule.Publish}
+	case h.rule.Log != nil:
+		return &logAction{h.rule.Log}
+	case h.rule.Handler != "":
+		return &handlerAction{h.rule.Handler, h.service.hulk.registry[h.rule.Handler]}
+	}
+
+	return nil
+}
+
+// hasAction tells whether the rule has an action
+func hasAction(rule HookRule) bool {
+	return rule.Command != "" || len(rule.Exec) > 0 || rule.HTTP != nil || rule.File != nil ||
+		rule.Publish != nil || rule.Log != nil || rule.Handler != ""
+}
+
+// validateAction checks whether the rule has at most one valid action
+func validateAction(rule HookRule) error {
+	actions := 0
+
+	if rule.Command != "" {
+		actions++
+	}
+
+	if len(rule.Exec) > 0 {
+		actions++
+
+		if rule.Exec[0] == "" {
+			return errors.New("Exec program is empty")
+		}
+	}
+
+	if rule.HTTP != nil {
+		actions++
+
+		if rule.HTTP.URL == "" {
+			return errors.New("HTTP URL is empty")
+		}
+	}
+
+	if rule.File != nil {
+		actions++
+
+		if rule.File.Path == "" {
+			return errors.New("File Path is empty")
+		}
+
+		if _, err := rule.File.mode(); err != nil {
+			return err
+		}
+	}
+
+	if rule.Publish != nil {
+		actions++
+
+		if rule.Publish.Topic == "" {
+			return errors.New("Publish Topic is empty")
+		}
+
+		if rule.Publish.QoS > 2 {
+			return errors.Errorf("invalid Publish QoS: %d", rule.Publish.QoS)
+		}
+	}
+
+	if rule.Log != nil {
+		actions++
+
+		if _, ok := logLevels[strings.ToLower(rule.Log.Level)]; !ok {
+			return errors.Errorf("invalid Log Level: %s", rule.Log.Level)
+		}
+	}
+
+	if rule.Handler != "" {
+		actions++
+	}
+
+	if actions > 1 {
+		return errors.New("Command, Exec, HTTP, File, Publish, Log and Handler are mutually exclusive")
+	}
+
+	return nil
+}
+
+// variableError adds the variable name to the message of variable expand errors
+func variableError(err error) error {
+	if ve, ok := err.(*template.VariableExpandError); ok {
+		return errors.Errorf("%s: %s", ve.Error(), ve.Name)
+	}
+
+	return err
+}
+
+// httpAction sends an HTTP request
+type httpAction struct {
+	*HTTPAction
+}
+
+func (a *httpAction) name() string {
+	return "http"
+}
+
+func (a *httpAction) run(ctx context.Context, h *Hook, variables map[string]string, payload []byte) error {
+	url, err := h.rule.expandString(a.URL, variables)
+	if err != nil {
+		return err
+	}
+
+	body := payload
+
+	if a.Body != "" {
+		expanded, err := h.rule.expandString(a.Body, variables)
+		if err != nil {
+			return err
+		}
+
+		body = []byte(expanded)
+	}
+
+	method := a.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for key, value := range a.Headers {
+		expanded, err := h.rule.expandString(value, variables)
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set(key, expanded)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 400 {
+		return errors.Errorf("HTTP request failed: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// fileAction writes the payload to a file
+type fileAction struct {
+	*FileAction
+}
+
+// mode returns the parsed octal file mode, defaults to 0600
+func (a *FileAction) mode() (os.FileMode, error) {
+	if a.Mode == "" {
+		return 0600, nil
+	}
+
+	mode, err := strconv.ParseUint(a.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.Errorf("invalid File Mode: %s", a.Mode)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+func (a *fileAction) name() string {
+	return "file"
+}
+
+func (a *fileAction) run(ctx context.Context, h *Hook, variables map[string]string, payload []byte) error {
+	path, err := h.rule.expandString(a.Path, variables)
+	if err != nil {
+		return err
+	}
+
+	// Captured topic levels may hold '..', the path must stay where the manifest points to
+	if dir := h.rule.fileDirectory; dir != "" && !insideDirectory(dir, path) {
+		return errors.Errorf("File Path %s is outside of %s", path, dir)
+	}
+
+	mode, err := a.mode()
+	if err != nil {
+		return err
+	}
+
+	credential, err := h.processOptions().credential()
+	if err != nil {
+		return err
+	}
+
+	if credential != nil {
+		return a.writeAs(ctx, h.processOptions(), path, mode, payload)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if a.Append {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, flags, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(payload)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// writeAs writes payload to the file at path as the User and Group of options, so the file
+// is opened with their permissions instead of hulkd ones, the mode is applied to new files
+func (a *fileAction) writeAs(ctx context.Context, options ProcessOptions, path string, mode os.FileMode, payload []byte) error {
+	redirect := ">"
+	if a.Append {
+		redirect = ">>"
+	}
+
+	script := fmt.Sprintf(`set -e; umask 0077; if [ ! -e "$1" ]; then : > "$1"; chmod %04o "$1"; fi; exec cat %s "$1"`, mode, redirect)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", script, "hulk", path)
+	cmd.Stdin = bytes.NewReader(payload)
+
+	if err := (ProcessOptions{User: options.User, Group: options.Group}).apply(cmd); err != nil {
+		return err
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return errors.Errorf("failed to write %s: %s", path, message)
+		}
+
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+
+	return nil
+}
+
+// staticDirectory returns the directory of the text before the first placeholder of tpl, or
+// an empty string if tpl has no placeholders or starts with one, so there is no such directory
+func staticDirectory(tpl *template.Template) string {
+	variables := tpl.Variables()
+	if len(variables) == 0 {
+		return ""
+	}
+
+	values := map[string]string{}
+	for _, variable := range variables {
+		values[variable.Name] = ""
+	}
+
+	// Mark where the placeholders are rendered
+	const marker = "\x00"
+
+	expanded, err := tpl.ExpandWith(values, template.Options{
+		Replace: func(string) string { return marker },
+	})
+	if err != nil || len(expanded) == 0 {
+		return ""
+	}
+
+	prefix := expanded[0]
+	if i := strings.Index(prefix, marker); i >= 0 {
+		prefix = prefix[:i]
+	}
+
+	switch i := strings.LastIndex(prefix, "/"); {
+	case prefix == "":
+		return ""
+	case i < 0:
+		return "."
+	case i == 0:
+		return "/"
+	default:
+		return prefix[:i]
+	}
+}
+
+// insideDirectory tells whether path is inside dir once cleaned
+func insideDirectory(dir, path string) bool {
+	rel, err := filepath.Rel(dir, filepath.Clean(path))
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// publishAction publishes a message to the broker
+type publishAction struct {
+	*PublishAction
+}
+
+func (a *publishAction) name() string {
+	return "publish"
+}
+
+func (a *publishAction) run(ctx context.Context, h *Hook, variables map[string]string, payload []byte) error {
+	topic, err := h.rule.expandString(a.Topic, variables)
+	if err != nil {
+		return err
+	}
+
+	if a.Payload != "" {
+		expanded, err := h.rule.expandString(a.Payload, variables)
+		if err != nil {
+			return err
+		}
+
+		payload = []byte(expanded)
+	}
+
+	client := h.client
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- client.Publish(topic, a.QoS, a.Retain, payload)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// logLevels holds the supported log action levels, info is the default
+var logLevels = map[string]bool{
+	"":      true,
+	"debug": true,
+	"info":  true,
+	"warn":  true,
+	"error": true,
+}
+
+// logAction writes a message to hulkd log
+type logAction struct {
+	*LogAction
+}
+
+func (a *logAction) name() string {
+	return "log"
+}
+
+func (a *logAction) run(ctx context.Context, h *Hook, variables map[string]string, payload []byte) error {
+	message, err := h.rule.expandString(a.Message, variables)
+	if err != nil {
+		return err
+	}
+
+	message = h.service.masker.String(message, variables)
+	entry := log.WithFields(h.logFields())
+
+	switch strings.ToLower(a.Level) {
+	case "debug":
+		entry.Debug(message)
+	case "warn":
+		entry.Warn(message)
+	case "error":
+		entry.Error(message)
+	default:
+		entry.Info(message)
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package hulk
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/OSSystems/hulk/pkg/secret"
+	"github.com/OSSystems/pkg/log"
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileActionPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		name          string
+		path          string
+		variables     map[string]string
+		expectedFile  string
+		expectedError string
+	}{
+		{
+			"Static",
+			dir + "/static.bin",
+			map[string]string{},
+			dir + "/static.bin",
+			"",
+		},
+
+		{
+			"Capture",
+			dir + "/{device}.bin",
+			map[string]string{"device": "a1"},
+			dir + "/a1.bin",
+			"",
+		},
+
+		{
+			"CaptureOutside",
+			dir + "/{device}/../../escaped.bin",
+			map[string]string{"device": "a1"},
+			"",
+			"File Path " + dir + "/a1/../../escaped.bin is outside of " + dir,
+		},
+
+		{
+			"CaptureParent",
+			dir + "/files/{device}.bin",
+			map[string]string{"device": "../../escaped"},
+			"",
+			"File Path " + dir + "/files/../../escaped.bin is outside of " + dir + "/files",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newRuleHook(t, HookRule{File: &FileAction{Path: tc.path}}, nil)
+
+			err = h.action().run(context.Background(), h, tc.variables, []byte("payload"))
+
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+
+			data, err := ioutil.ReadFile(tc.expectedFile)
+			assert.NoError(t, err)
+			assert.Equal(t, "payload", string(data))
+		})
+	}
+
+	_, err = os.Stat(filepath.Join(filepath.Dir(dir), "escaped.bin"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStaticDirectory(t *testing.T) {
+	testCases := []struct {
+		name              string
+		path              string
+		expectedDirectory string
+	}{
+		{"Static", "/var/lib/hulk/file.bin", ""},
+		{"Placeholder", "/var/lib/hulk/{device}.bin", "/var/lib/hulk"},
+		{"PlaceholderLevel", "/var/lib/{device}/file.bin", "/var/lib"},
+		{"EscapedBrace", "/var/lib/{{a}/{device}", "/var/lib/{a}"},
+		{"Root", "/{device}.bin", "/"},
+		{"Relative", "files-{device}.bin", "."},
+		{"StartsWithPlaceholder", "{HOME}/file.bin", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := newHookRule(0, HookRule{File: &FileAction{Path: tc.path}}, ProcessOptions{})
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedDirectory, rule.fileDirectory)
+		})
+	}
+}
+
+func TestHTTPAction(t *testing.T) {
+	testCases := []struct {
+		name            string
+		action          HTTPAction
+		status          int
+		expectedMethod  string
+		expectedPath    string
+		expectedHeaders map[string]string
+		expectedBody    string
+		expectedError   string
+	}{
+		{
+			"Payload",
+			HTTPAction{URL: "/devices/{device}"},
+			http.StatusOK,
+			"POST",
+			"/devices/a1",
+			map[string]string{},
+			`{"state": "on"}`,
+			"",
+		},
+
+		{
+			"Templates",
+			HTTPAction{
+				Method:  "PUT",
+				URL:     "/devices/{device}/state",
+				Headers: map[string]string{"X-Device": "{device}", "Content-Type": "text/plain"},
+				Body:    "{payload.state}",
+			},
+			http.StatusNoContent,
+			"PUT",
+			"/devices/a1/state",
+			map[string]string{"X-Device": "a1", "Content-Type": "text/plain"},
+			"on",
+			"",
+		},
+
+		{
+			"Failed",
+			HTTPAction{URL: "/devices/{device}"},
+			http.StatusNotFound,
+			"POST",
+			"/devices/a1",
+			map[string]string{},
+			`{"state": "on"}`,
+			"HTTP request failed: 404 Not Found",
+		},
+
+		{
+			"MissingVariable",
+			HTTPAction{URL: "/devices/{device}", Body: "{payload.missing}"},
+			http.StatusOK,
+			"",
+			"",
+			nil,
+			"",
+			"No value for required variable: payload.missing",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var request *http.Request
+			var body []byte
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				request = r
+				body, _ = ioutil.ReadAll(r.Body)
+
+				w.WriteHeader(tc.status)
+			}))
+
+			defer server.Close()
+
+			action := tc.action
+			action.URL = server.URL + action.URL
+
+			h := newRuleHook(t, HookRule{HTTP: &action}, nil)
+			h.captures = map[string]string{"device": "a1"}
+
+			payload := []byte(`{"state": "on"}`)
+
+			variables, err := h.variables(payload)
+			assert.NoError(t, err)
+
+			err = h.action().run(context.Background(), h, variables, payload)
+
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tc.expectedMethod == "" {
+				assert.Nil(t, request)
+				return
+			}
+
+			assert.Equal(t, tc.expectedMethod, request.Method)
+			assert.Equal(t, tc.expectedPath, request.URL.Path)
+			assert.Equal(t, tc.expectedBody, string(body))
+
+			for key, value := range tc.expectedHeaders {
+				assert.Equal(t, value, request.Header.Get(key))
+			}
+		})
+	}
+}
+
+func TestPublishAction(t *testing.T) {
+	testCases := []struct {
+		name             string
+		action           PublishAction
+		publishError     error
+		expectedMessages []fakeMessage
+		expectedError    string
+	}{
+		{
+			"Republished",
+			PublishAction{Topic: "archive/{device}"},
+			nil,
+			[]fakeMessage{{topic: "archive/a1", payload: `{"state": "on"}`}},
+			"",
+		},
+
+		{
+			"Payload",
+			PublishAction{Topic: "states/{device}", Payload: "{payload.state}", QoS: 1, Retain: true},
+			nil,
+			[]fakeMessage{{topic: "states/a1", qos: 1, retained: true, payload: "on"}},
+			"",
+		},
+
+		{
+			"Failed",
+			PublishAction{Topic: "archive/{device}"},
+			errors.New("not connected"),
+			nil,
+			"not connected",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			action := tc.action
+
+			h := newRuleHook(t, HookRule{Publish: &action}, nil)
+			h.captures = map[string]string{"device": "a1"}
+
+			client := h.client.(*fakeClient)
+			client.publishError = tc.publishError
+
+			payload := []byte(`{"state": "on"}`)
+
+			variables, err := h.variables(payload)
+			assert.NoError(t, err)
+
+			err = h.action().run(context.Background(), h, variables, payload)
+
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expectedMessages, client.published)
+		})
+	}
+}
+
+// logHook records the log entries
+type logHook struct {
+	lock    sync.Mutex
+	entries []string
+}
+
+func (l *logHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel, logrus.WarnLevel, logrus.InfoLevel, logrus.DebugLevel}
+}
+
+func (l *logHook) Fire(entry *logrus.Entry) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.entries = append(l.entries, entry.Level.String()+" "+entry.Message)
+
+	return nil
+}
+
+func TestLogAction(t *testing.T) {
+	hook := &logHook{}
+	log.WithFields(nil).Logger.Hooks.Add(hook)
+
+	level := log.GetLevel()
+	log.SetLevel(logrus.DebugLevel)
+
+	defer log.SetLevel(level)
+
+	testCases := []struct {
+		name          string
+		action        LogAction
+		expectedEntry string
+	}{
+		{"Default", LogAction{Message: "state of {device}: {payload.state}"}, "info state of a1: on"},
+		{"Debug", LogAction{Level: "debug", Message: "{device}"}, "debug a1"},
+		{"Warn", LogAction{Level: "WARN", Message: "{device}"}, "warning a1"},
+		{"Error", LogAction{Level: "error", Message: "{device}"}, "error a1"},
+		{"Secret", LogAction{Message: "token {TOKEN}"}, "info token " + secret.Mask},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			action := tc.action
+
+			h := newRuleHook(t, HookRule{Log: &action}, nil)
+			h.captures = map[string]string{"device": "a1"}
+			h.environment["TOKEN"] = "s3cr3t"
+
+			payload := []byte(`{"state": "on"}`)
+
+			variables, err := h.variables(payload)
+			assert.NoError(t, err)
+
+			hook.lock.Lock()
+			hook.entries = nil
+			hook.lock.Unlock()
+
+			assert.NoError(t, h.action().run(context.Background(), h, variables, payload))
+
+			hook.lock.Lock()
+			defer hook.lock.Unlock()
+
+			assert.Contains(t, hook.entries, tc.expectedEntry)
+		})
+	}
+}
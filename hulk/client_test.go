@@ -0,0 +1,62 @@
+package hulk
+
+import (
+	"github.com/OSSystems/hulk/mqtt"
+)
+
+// fakeClient is an MQTT client which records the subscription changes
+type fakeClient struct {
+	disconnected bool
+	handlers     map[string]mqtt.MqttMessageHandler
+	// calls holds the subscribe and unsubscribe calls in order
+	calls []string
+	// published holds the published messages, publishError is returned by Publish if set
+	published    []fakeMessage
+	publishError error
+}
+
+// fakeMessage is a message published through fakeClient
+type fakeMessage struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{handlers: map[string]mqtt.MqttMessageHandler{}}
+}
+
+func (c *fakeClient) Connect() error {
+	c.disconnected = false
+	return nil
+}
+
+func (c *fakeClient) Disconnect() {
+	c.disconnected = true
+}
+
+func (c *fakeClient) IsConnected() bool {
+	return !c.disconnected
+}
+
+func (c *fakeClient) Subscribe(topic string, qos byte, callback mqtt.MqttMessageHandler) error {
+	c.handlers[topic] = callback
+	c.calls = append(c.calls, "subscribe "+topic)
+	return nil
+}
+
+func (c *fakeClient) Unsubscribe(topic string) {
+	delete(c.handlers, topic)
+	c.calls = append(c.calls, "unsubscribe "+topic)
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	if c.publishError != nil {
+		return c.publishError
+	}
+
+	c.published = append(c.published, fakeMessage{topic: topic, qos: qos, retained: retained, payload: string(payload)})
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package hulk
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OSSystems/hulk/api/types"
+	"github.com/OSSystems/pkg/log"
+	"github.com/Sirupsen/logrus"
+)
+
+// Services are ordered by their manifest dependencies:
+//
+//  - 'Requires' lists the services which must be enabled before the service is enabled,
+//    the service is also loaded after them
+//  - 'After' lists the services which the service is loaded after, if they exist
+//
+// Services in a dependency cycle are reported and disabled.
+
+// dependencies returns the names of the services which service is loaded after
+func (s *Service) dependencies() []string {
+	return append(append([]string{}, s.manifest.Requires...), s.manifest.After...)
+}
+
+// requires tells whether service requires a service in set
+func (s *Service) requires(set map[*Service]bool) bool {
+	for _, name := range s.manifest.Requires {
+		for service := range set {
+			if service.name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// sortServices sorts services so that each one comes after its dependencies,
+// keeping the original order otherwise, and returns the dependency cycles found
+//
+// The services of a cycle are sorted in no particular order between them.
+func sortServices(services []*Service) ([]*Service, [][]string) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	byName := map[string]*Service{}
+	for _, service := range services {
+		byName[service.name] = service
+	}
+
+	state := map[*Service]int{}
+	cycles := [][]string{}
+	sorted := []*Service{}
+	stack := []*Service{}
+
+	var visit func(service *Service)
+	visit = func(service *Service) {
+		switch state[service] {
+		case visited:
+			return
+		case visiting:
+			cycle := []string{}
+
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == service {
+					for _, s := range stack[i:] {
+						cycle = append(cycle, s.name)
+					}
+
+					break
+				}
+			}
+
+			cycles = append(cycles, append(cycle, service.name))
+			return
+		}
+
+		state[service] = visiting
+		stack = append(stack, service)
+
+		for _, name := range service.dependencies() {
+			if dependency, ok := byName[name]; ok {
+				visit(dependency)
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[service] = visited
+
+		sorted = append(sorted, service)
+	}
+
+	for _, service := range services {
+		visit(service)
+	}
+
+	return sorted, cycles
+}
+
+// logCycles reports dependency cycles
+func logCycles(cycles [][]string) {
+	for _, cycle := range cycles {
+		log.WithFields(logrus.Fields{
+			"cycle": strings.Join(cycle, " -> "),
+		}).Error("dependency cycle detected, services disabled")
+	}
+}
+
+// serviceCycle returns the first of cycles containing the service named name or nil if there is none
+func serviceCycle(cycles [][]string, name string) []string {
+	for _, cycle := range cycles {
+		for _, n := range cycle {
+			if n == name {
+				return cycle
+			}
+		}
+	}
+
+	return nil
+}
+
+// cycleStatus returns the status of the services in cycle
+func cycleStatus(cycle []string) *types.ServiceStatus {
+	return &types.ServiceStatus{
+		Reason:  types.StatusReasonDependencyCycle,
+		Message: "dependency cycle: " + strings.Join(cycle, " -> "),
+	}
+}
+
+// dependenciesEnabled tells whether all services required by service are enabled
+func (h *Hulk) dependenciesEnabled(service *Service) bool {
+	for _, name := range service.manifest.Requires {
+		required := h.service(name)
+
+		if required == nil || !required.enabled {
+			log.WithFields(logrus.Fields{
+				"service":  service.name,
+				"requires": name,
+			}).Info("waiting for required service")
+
+			message := fmt.Sprintf("required service %s is disabled", name)
+			if required == nil {
+				message = fmt.Sprintf("required service %s does not exist", name)
+			}
+
+			service.status = &types.ServiceStatus{
+				Reason:  types.StatusReasonRequiredService,
+				Message: message,
+				Service: name,
+			}
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// reloadAffected reloads the affected services along with the services requiring them
+func (h *Hulk) reloadAffected(affected map[*Service]bool) {
+	// Services are sorted by dependencies, so the required ones are reloaded first
+	for _, service := range h.services {
+		if !affected[service] && !service.requires(affected) {
+			continue
+		}
+
+		affected[service] = true
+
+		h.reloadService(service)
+	}
+}
+
+// reloadDependents reloads the services requiring service
+func (h *Hulk) reloadDependents(service *Service) {
+	changed := map[*Service]bool{service: true}
+
+	for _, s := range h.services {
+		if s == service || !s.requires(changed) {
+			continue
+		}
+
+		changed[s] = true
+
+		h.reloadService(s)
+	}
+}
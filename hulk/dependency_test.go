@@ -0,0 +1,229 @@
+package hulk
+
+import (
+	"testing"
+
+	"github.com/OSSystems/hulk/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// testService returns a service named name with the dependencies of its manifest
+func testService(name string, requires []string, after []string) *Service {
+	return &Service{name: name, manifest: Manifest{Requires: requires, After: after}}
+}
+
+func TestSortServices(t *testing.T) {
+	testCases := []struct {
+		name           string
+		services       []*Service
+		expectedOrder  []string
+		expectedCycles [][]string
+	}{
+		{
+			"NoDependencies",
+			[]*Service{
+				testService("a", nil, nil),
+				testService("b", nil, nil),
+			},
+			[]string{"a", "b"},
+			[][]string{},
+		},
+
+		{
+			"Requires",
+			[]*Service{
+				testService("a", []string{"b"}, nil),
+				testService("b", nil, nil),
+			},
+			[]string{"b", "a"},
+			[][]string{},
+		},
+
+		{
+			"After",
+			[]*Service{
+				testService("a", nil, []string{"c"}),
+				testService("b", nil, nil),
+				testService("c", nil, nil),
+			},
+			[]string{"c", "a", "b"},
+			[][]string{},
+		},
+
+		{
+			"AfterMissing",
+			[]*Service{
+				testService("a", nil, []string{"missing"}),
+				testService("b", nil, nil),
+			},
+			[]string{"a", "b"},
+			[][]string{},
+		},
+
+		{
+			"Chain",
+			[]*Service{
+				testService("a", []string{"b"}, nil),
+				testService("b", nil, []string{"c"}),
+				testService("c", nil, nil),
+			},
+			[]string{"c", "b", "a"},
+			[][]string{},
+		},
+
+		{
+			"Cycle",
+			[]*Service{
+				testService("a", []string{"b"}, nil),
+				testService("b", nil, []string{"a"}),
+				testService("c", nil, nil),
+			},
+			[]string{"b", "a", "c"},
+			[][]string{{"a", "b", "a"}},
+		},
+
+		{
+			"SelfCycle",
+			[]*Service{
+				testService("a", []string{"a"}, nil),
+			},
+			[]string{"a"},
+			[][]string{{"a", "a"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sorted, cycles := sortServices(tc.services)
+
+			order := []string{}
+			for _, service := range sorted {
+				order = append(order, service.name)
+			}
+
+			assert.Equal(t, tc.expectedOrder, order)
+			assert.Equal(t, tc.expectedCycles, cycles)
+		})
+	}
+}
+
+func TestServiceCycle(t *testing.T) {
+	cycles := [][]string{{"a", "b", "a"}, {"c", "c"}}
+
+	assert.Equal(t, []string{"a", "b", "a"}, serviceCycle(cycles, "b"))
+	assert.Equal(t, []string{"c", "c"}, serviceCycle(cycles, "c"))
+	assert.Nil(t, serviceCycle(cycles, "d"))
+}
+
+// newDependencyHulk returns a Hulk with loaded services: a, b requiring a,
+// c requiring b and d without dependencies
+func newDependencyHulk(t *testing.T) *Hulk {
+	h, err := NewHulk(newFakeClient(), "")
+	assert.NoError(t, err)
+
+	manifests := map[string]Manifest{
+		"c": {Topics: []string{"c"}, Requires: []string{"b"}},
+		"b": {Topics: []string{"b"}, Requires: []string{"a"}},
+		"a": {Topics: []string{"a"}},
+		"d": {Topics: []string{"d"}},
+	}
+
+	for _, name := range []string{"c", "b", "a", "d"} {
+		assert.NoError(t, h.AddService(name, manifests[name]))
+	}
+
+	assert.NoError(t, h.LoadServices())
+
+	return h
+}
+
+// enabledServices returns whether each service of h is enabled by name
+func enabledServices(h *Hulk) map[string]bool {
+	enabled := map[string]bool{}
+
+	for _, service := range h.services {
+		enabled[service.name] = service.enabled
+	}
+
+	return enabled
+}
+
+func TestLoadServicesDependencies(t *testing.T) {
+	h := newDependencyHulk(t)
+
+	order := []string{}
+	for _, service := range h.services {
+		order = append(order, service.name)
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, order)
+	assert.Equal(t, map[string]bool{"a": true, "b": true, "c": true, "d": true}, enabledServices(h))
+}
+
+func TestReloadAffected(t *testing.T) {
+	testCases := []struct {
+		name            string
+		disabled        string
+		affected        []string
+		expectedEnabled map[string]bool
+	}{
+		{
+			"Required",
+			"a",
+			[]string{"a"},
+			map[string]bool{"a": false, "b": false, "c": false, "d": true},
+		},
+
+		{
+			"Middle",
+			"b",
+			[]string{"b"},
+			map[string]bool{"a": true, "b": false, "c": false, "d": true},
+		},
+
+		{
+			"Independent",
+			"d",
+			[]string{"d"},
+			map[string]bool{"a": true, "b": true, "c": true, "d": false},
+		},
+
+		{
+			"NotAffected",
+			"a",
+			[]string{"d"},
+			map[string]bool{"a": true, "b": true, "c": true, "d": true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newDependencyHulk(t)
+			h.disabled[tc.disabled] = true
+
+			affected := map[*Service]bool{}
+			for _, name := range tc.affected {
+				affected[h.service(name)] = true
+			}
+
+			h.reloadAffected(affected)
+
+			assert.Equal(t, tc.expectedEnabled, enabledServices(h))
+		})
+	}
+}
+
+func TestReloadDependents(t *testing.T) {
+	h := newDependencyHulk(t)
+
+	assert.NoError(t, h.DisableService("a"))
+	assert.Equal(t, map[string]bool{"a": false, "b": false, "c": false, "d": true}, enabledServices(h))
+
+	status := h.service("b").status
+	assert.Equal(t, types.StatusReasonRequiredService, status.Reason)
+	assert.Equal(t, "a", status.Service)
+
+	assert.NoError(t, h.EnableService("a"))
+	assert.Equal(t, map[string]bool{"a": true, "b": true, "c": true, "d": true}, enabledServices(h))
+	assert.Nil(t, h.service("c").status)
+}
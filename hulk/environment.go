@@ -0,0 +1,124 @@
+package hulk
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OSSystems/pkg/log"
+	"github.com/Sirupsen/logrus"
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+)
+
+// The hook environment is built from the following sources, each one
+// overriding the variables of the previous ones:
+//
+//  1. host environment variables allowed by the service 'PassEnvironment'
+//  2. the daemon default environment file
+//  3. the service 'EnvironmentFiles', in the listed order
+//  4. the service inline 'Environment'
+//  5. the hook rule inline 'Environment'
+//  6. the hook variables: named topic captures, TOPIC and the payload variables
+//
+// Topic captures colliding with the service environment or with the hook rule inline
+// 'Environment' are rejected when the topics are expanded.
+//
+// Sources 1 to 4 make the service environment, which is also used to expand the topics.
+// The service inline values are compiled when the manifest is loaded and expanded with
+// sources 1 to 3, a variable which fails to expand is left out with a warning.
+
+// SetEnvironmentFile sets the daemon default environment file shared by all services
+func (h *Hulk) SetEnvironmentFile(file string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.environmentFile = file
+	h.loadEnvironment()
+
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		log.WithFields(logrus.Fields{"file": file}).Warn("environment file does not exists")
+	}
+
+	return h.fwatcher.AddDebounced(file, h.debounce)
+}
+
+// SetDebounce sets the time without changes to wait before reloading the services of a
+// changed environment file, it must be called before setting environment files or loading services
+func (h *Hulk) SetDebounce(debounce time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.debounce = debounce
+}
+
+// loadEnvironment loads the daemon default environment file
+func (h *Hulk) loadEnvironment() {
+	h.environment = map[string]string{}
+
+	if h.environmentFile == "" {
+		return
+	}
+
+	env, err := readEnvironmentFile(h.environmentFile)
+	if err != nil {
+		log.WithFields(logrus.Fields{"file": h.environmentFile}).Warn(err)
+		return
+	}
+
+	h.environment = env
+}
+
+// readEnvironmentFile reads variables from an environment file, a missing file has no variables
+func readEnvironmentFile(file string) (map[string]string, error) {
+	env, err := godotenv.Read(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+
+		return nil, errors.Wrapf(err, "failed to parse environment file")
+	}
+
+	return env, nil
+}
+
+// hostEnvironment returns the host environment variables matching the allowed name patterns
+func hostEnvironment(allowed []string) map[string]string {
+	env := map[string]string{}
+
+	for _, variable := range os.Environ() {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		for _, pattern := range allowed {
+			if matched, _ := path.Match(pattern, parts[0]); matched {
+				env[parts[0]] = parts[1]
+				break
+			}
+		}
+	}
+
+	return env
+}
+
+// environmentList converts env to a sorted KEY=VALUE list
+func environmentList(env map[string]string) []string {
+	keys := []string{}
+	for key := range env {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	list := []string{}
+	for _, key := range keys {
+		list = append(list, key+"="+env[key])
+	}
+
+	return list
+}
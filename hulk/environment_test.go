@@ -0,0 +1,104 @@
+package hulk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironmentPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	// Each source sets its own variable and the variables of the following sources
+	for _, key := range []string{"HOST", "DAEMON", "FILE", "INLINE", "RULE"} {
+		os.Setenv("HULK_TEST_"+key, "host")
+		defer os.Unsetenv("HULK_TEST_" + key)
+	}
+
+	writeFile := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+		return file
+	}
+
+	daemonFile := writeFile("daemon.env", "HULK_TEST_DAEMON=daemon\nHULK_TEST_FILE=daemon\nHULK_TEST_INLINE=daemon\nHULK_TEST_RULE=daemon\n")
+	firstFile := writeFile("first.env", "HULK_TEST_FILE=first\nHULK_TEST_INLINE=first\nHULK_TEST_RULE=first\n")
+	secondFile := writeFile("second.env", "HULK_TEST_FILE=second\n")
+
+	h, err := NewHulk(newFakeClient(), "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.SetEnvironmentFile(daemonFile))
+
+	manifest := Manifest{
+		Topics:           []string{"devices/+"},
+		PassEnvironment:  []string{"HULK_TEST_*"},
+		EnvironmentFiles: []string{firstFile, secondFile},
+		Environment: map[string]string{
+			"HULK_TEST_INLINE":   "inline",
+			"HULK_TEST_RULE":     "inline",
+			"HULK_TEST_EXPANDED": "{HULK_TEST_FILE}",
+			"HULK_TEST_MISSING":  "{MISSING}",
+		},
+		Hooks: ManifestHooks{OnReceive: HookRules{{
+			Topic:   "devices/{+device}",
+			Command: "true",
+			Environment: map[string]string{
+				"HULK_TEST_RULE": "rule {device} {payload.state} {HULK_TEST_INLINE}",
+				"TOPIC":          "rule",
+			},
+		}}},
+	}
+
+	assert.NoError(t, h.AddService("devices", manifest))
+	assert.NoError(t, h.LoadServices())
+
+	service := h.service("devices")
+
+	// A variable which fails to expand does not drop the other inline variables
+	assert.Equal(t, "inline", service.environment["HULK_TEST_INLINE"])
+	assert.NotContains(t, service.environment, "HULK_TEST_MISSING")
+
+	rule := service.rules[OnReceiveHook][0]
+
+	payload := []byte(`{"state": "on"}`)
+
+	captures, ok := rule.match("devices/a1", payload)
+	assert.True(t, ok)
+
+	variables, err := NewHook(service, OnReceiveHook, rule, "devices/a1", captures).variables(payload)
+	assert.NoError(t, err)
+
+	expected := map[string]string{
+		"HULK_TEST_HOST":     "host",
+		"HULK_TEST_DAEMON":   "daemon",
+		"HULK_TEST_FILE":     "second",
+		"HULK_TEST_INLINE":   "inline",
+		"HULK_TEST_RULE":     "rule a1 on inline",
+		"HULK_TEST_EXPANDED": "second",
+		"device":             "a1",
+		"TOPIC":              "devices/a1",
+		"payload.state":      "on",
+	}
+
+	for key, value := range expected {
+		assert.Equal(t, value, variables[key], key)
+	}
+
+	assert.NotContains(t, variables, "HULK_TEST_MISSING")
+}
+
+func TestInvalidServiceEnvironment(t *testing.T) {
+	h, err := NewHulk(newFakeClient(), "")
+	assert.NoError(t, err)
+
+	_, err = newService(h, "devices", Manifest{Environment: map[string]string{"DEVICE": "{device"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid Environment DEVICE")
+}
@@ -0,0 +1,247 @@
+package hulk
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/OSSystems/hulk/template"
+	"github.com/OSSystems/pkg/log"
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+// execAction runs the 'Command' or 'Exec' of a hook rule
+type execAction struct{}
+
+func (a *execAction) name() string {
+	return "exec"
+}
+
+// run runs the hook command and waits for it to exit, killing it along with its children when ctx is done
+func (a *execAction) run(ctx context.Context, h *Hook, variables map[string]string, payload []byte) error {
+	logFields := h.logFields()
+
+	payloadFile := ""
+
+	if h.rule.Payload == PayloadFile {
+		var err error
+		if payloadFile, err = h.createPayloadFile(payload); err != nil {
+			return err
+		}
+
+		defer removePayloadFile(payloadFile, logFields)
+
+		variables[PayloadFileVariable] = payloadFile
+	}
+
+	cmd, helperError, err := h.createCmd(variables)
+	if err != nil {
+		return err
+	}
+
+	if log.GetLevel() == logrus.DebugLevel {
+		masker := h.service.masker
+
+		log.WithFields(logFields).WithFields(logrus.Fields{
+			"cmd":     masker.Strings(cmd.Args, variables),
+			"env":     masker.Strings(masker.List(cmd.Env), variables),
+			"payload": masker.String(string(payload), variables),
+		}).Debug("running command")
+	}
+
+	var stdin io.WriteCloser
+
+	if payloadFile == "" {
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cmd.Start()
+
+	// The process helper reports the error which prevented it from executing the command
+	if helperErr := helperError(); err == nil && helperErr != nil {
+		cmd.Wait()
+		return helperErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Write the payload in background since a large payload blocks until the hook reads it
+	written := make(chan error, 1)
+
+	if stdin != nil {
+		go func() {
+			written <- writePayload(stdin, payload)
+		}()
+	} else {
+		written <- nil
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+		// The payload write ends once the command exits and its stdin is closed
+		if err == nil {
+			err = <-written
+		}
+
+		return err
+	case <-ctx.Done():
+		if err := kill(cmd); err != nil {
+			log.WithFields(logFields).Warn(err)
+		}
+
+		<-done
+
+		return ctx.Err()
+	}
+}
+
+// expandArgs expands the 'Exec' argv list of the hook
+func (h *Hook) expandArgs(variables map[string]string) ([]string, error) {
+	args := []string{}
+
+	for _, arg := range h.rule.Exec {
+		expanded, err := h.rule.expand(arg, variables)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, expanded...)
+	}
+
+	if len(args) == 0 || args[0] == "" {
+		return nil, errors.New("Exec program is empty")
+	}
+
+	return args, nil
+}
+
+// processOptions returns the process attributes of the hook command
+func (h *Hook) processOptions() ProcessOptions {
+	return h.rule.options
+}
+
+// createCmd creates command, along with the function returning the error of the process
+// helper which must be called once the command was started, see ProcessOptions.wrap
+func (h *Hook) createCmd(variables map[string]string) (*exec.Cmd, func() error, error) {
+	var args []string
+
+	env := exportedVariables(variables)
+
+	if len(h.rule.Exec) > 0 {
+		var err error
+		if args, err = h.expandArgs(variables); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		tpl, err := h.rule.template(commandTemplate(h.rule.Command))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		command, values, err := expandCommand(tpl, variables)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for key, value := range values {
+			env[key] = value
+		}
+
+		args = []string{"sh", "-c", command}
+	}
+
+	options := h.processOptions()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = environmentList(env)
+
+	if err := options.apply(cmd); err != nil {
+		return nil, nil, err
+	}
+
+	if options.WorkingDirectory != "" {
+		dir, err := h.rule.expandString(options.WorkingDirectory, variables)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "WorkingDirectory")
+		}
+
+		cmd.Dir = dir
+	}
+
+	helperError, err := options.wrap(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cmd, helperError, nil
+}
+
+// commandTemplate returns the template of the shell command, where the shell
+// parameter expansions like ${VAR} are escaped so they are left to the shell
+func commandTemplate(command string) string {
+	return strings.Replace(command, "${", "${{", -1)
+}
+
+// commandValuePrefix is the prefix of the environment variables holding the values
+// of the shell command placeholders
+const commandValuePrefix = "HULK_VALUE_"
+
+// expandCommand replaces the placeholders of the shell command template, see commandTemplate,
+// with references to environment variables holding their values, returned along with the
+// command, so the values are never parsed as shell code, placeholders of variables without
+// value are left to the shell
+//
+// Placeholders expand like shell parameters: a value with spaces is a single word
+// only when the placeholder is double quoted, like "{payload.name}". Array placeholders
+// expand in place to a double quoted reference for each element, so each element is a
+// single word, they must not be quoted.
+func expandCommand(tpl *template.Template, variables map[string]string) (string, map[string]string, error) {
+	values := map[string]string{}
+
+	expanded, err := tpl.ExpandWith(variables, template.Options{
+		Replace: func(value string) string {
+			name := commandValuePrefix + strconv.Itoa(len(values)+1)
+			values[name] = value
+
+			return "${" + name + "}"
+		},
+		Join: func(elements []string) string {
+			return `"` + strings.Join(elements, `" "`) + `"`
+		},
+		KeepMissing: true,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Arrays are joined in place, so there is a single command
+	return expanded[0], values, nil
+}
+
+// exportedVariables returns the variables exported to the hook command environment,
+// the payload variables are only available to templates
+func exportedVariables(variables map[string]string) map[string]string {
+	exported := map[string]string{}
+
+	for key, value := range variables {
+		if !isPayloadVariable(key) {
+			exported[key] = value
+		}
+	}
+
+	return exported
+}
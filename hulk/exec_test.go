@@ -0,0 +1,88 @@
+package hulk
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/OSSystems/hulk/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandCommand(t *testing.T) {
+	testCases := []struct {
+		name            string
+		command         string
+		variables       map[string]string
+		expectedCommand string
+		expectedValues  map[string]string
+	}{
+		{
+			"Plain",
+			"echo hello",
+			map[string]string{},
+			"echo hello",
+			map[string]string{},
+		},
+
+		{
+			"Placeholders",
+			`echo "dev={payload.name}" {DEVICE}`,
+			map[string]string{"payload.name": "a b", "DEVICE": "a1"},
+			`echo "dev=${HULK_VALUE_1}" ${HULK_VALUE_2}`,
+			map[string]string{"HULK_VALUE_1": "a b", "HULK_VALUE_2": "a1"},
+		},
+
+		{
+			"Array",
+			"echo {LIST[,]} {DEVICE}",
+			map[string]string{"LIST": "a,b c", "DEVICE": "a1"},
+			`echo "${HULK_VALUE_1}" "${HULK_VALUE_2}" ${HULK_VALUE_3}`,
+			map[string]string{"HULK_VALUE_1": "a", "HULK_VALUE_2": "b c", "HULK_VALUE_3": "a1"},
+		},
+
+		{
+			"ShellParameter",
+			"echo ${HOME} {MISSING}",
+			map[string]string{},
+			"echo ${HOME} {MISSING}",
+			map[string]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tpl, err := template.Compile(commandTemplate(tc.command))
+			assert.NoError(t, err)
+
+			command, values, err := expandCommand(tpl, tc.variables)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCommand, command)
+			assert.Equal(t, tc.expectedValues, values)
+		})
+	}
+}
+
+func TestExpandCommandInjection(t *testing.T) {
+	values := []string{
+		`'; echo injected; '`,
+		`"; echo injected; "`,
+		"$(echo injected)",
+		"`echo injected`",
+		"a b\necho injected",
+	}
+
+	tpl, err := template.Compile(`printf %s "{payload.name}"`)
+	assert.NoError(t, err)
+
+	for _, value := range values {
+		command, env, err := expandCommand(tpl, map[string]string{"payload.name": value})
+		assert.NoError(t, err)
+
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Env = environmentList(env)
+
+		output, err := cmd.Output()
+		assert.NoError(t, err)
+		assert.Equal(t, value, string(output))
+	}
+}
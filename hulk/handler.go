@@ -0,0 +1,86 @@
+package hulk
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Handler handles the messages of hook rules referencing it by name in 'Handler'
+//
+// Handle is called in its own goroutine and must return as soon as ctx is done.
+type Handler interface {
+	Handle(ctx context.Context, msg *Message) error
+}
+
+// HandlerFunc is an adapter to use ordinary functions as handlers
+type HandlerFunc func(ctx context.Context, msg *Message) error
+
+// Handle calls f(ctx, msg)
+func (f HandlerFunc) Handle(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// Message represents a received message passed to a handler
+type Message struct {
+	// Service is the name of the service which received the message
+	Service string
+	// Rule is the name of the matched hook rule
+	Rule string
+	// Topic is the topic the message was received on
+	Topic string
+	// Payload is the message payload
+	Payload []byte
+	// Variables holds the hook variables, the ones exported to hook commands along with
+	// the payload.* variables referenced by the rule templates, each attempt gets its own copy
+	Variables map[string]string
+}
+
+// RegisterHandler registers handler by name, it must be called before loading services
+func (h *Hulk) RegisterHandler(name string, handler Handler) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if name == "" {
+		return errors.New("handler name is empty")
+	}
+
+	if handler == nil {
+		return errors.Errorf("handler %s is nil", name)
+	}
+
+	if _, ok := h.registry[name]; ok {
+		return errors.Errorf("handler %s already registered", name)
+	}
+
+	h.registry[name] = handler
+
+	return nil
+}
+
+// handlerAction calls a registered handler
+type handlerAction struct {
+	handlerName string
+	// handler is nil if no handler is registered by handlerName
+	handler Handler
+}
+
+func (a *handlerAction) name() string {
+	return "handler"
+}
+
+func (a *handlerAction) run(ctx context.Context, h *Hook, variables map[string]string, payload []byte) error {
+	if a.handler == nil {
+		return errors.Errorf("unknown Handler: %s", a.handlerName)
+	}
+
+	msg := &Message{
+		Service:   h.service.name,
+		Rule:      h.rule.Name,
+		Topic:     h.topic,
+		Payload:   payload,
+		Variables: variables,
+	}
+
+	return a.handler.Handle(ctx, msg)
+}
@@ -0,0 +1,118 @@
+package hulk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterHandler(t *testing.T) {
+	handler := HandlerFunc(func(ctx context.Context, msg *Message) error { return nil })
+
+	testCases := []struct {
+		name          string
+		handlerName   string
+		handler       Handler
+		expectedError string
+	}{
+		{"Registered", "notify", handler, ""},
+		{"EmptyName", "", handler, "handler name is empty"},
+		{"Nil", "notify", nil, "handler notify is nil"},
+		{"Duplicate", "existing", handler, "handler existing already registered"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := NewHulk(newFakeClient(), "")
+			assert.NoError(t, err)
+
+			assert.NoError(t, h.RegisterHandler("existing", handler))
+
+			err = h.RegisterHandler(tc.handlerName, tc.handler)
+
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestHandlerAction(t *testing.T) {
+	client := newFakeClient()
+
+	h, err := NewHulk(client, "")
+	assert.NoError(t, err)
+
+	messages := make(chan *Message, 1)
+
+	assert.NoError(t, h.RegisterHandler("notify", HandlerFunc(func(ctx context.Context, msg *Message) error {
+		messages <- msg
+		return nil
+	})))
+
+	manifest := Manifest{
+		Topics:      []string{"devices/+/status"},
+		Environment: map[string]string{"SITE": "lab"},
+		Hooks: ManifestHooks{OnReceive: HookRules{
+			{Name: "status", Topic: "devices/{+device}/status", Handler: "notify", Environment: map[string]string{"STATE": "{payload.state}"}},
+		}},
+	}
+
+	assert.NoError(t, h.AddService("devices", manifest))
+	assert.NoError(t, h.LoadServices())
+
+	client.handlers["devices/+/status"]("devices/a1/status", []byte(`{"state": "on"}`))
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "devices", msg.Service)
+		assert.Equal(t, "status", msg.Rule)
+		assert.Equal(t, "devices/a1/status", msg.Topic)
+		assert.Equal(t, `{"state": "on"}`, string(msg.Payload))
+		assert.Equal(t, "a1", msg.Variables["device"])
+		assert.Equal(t, "devices/a1/status", msg.Variables["TOPIC"])
+		assert.Equal(t, "lab", msg.Variables["SITE"])
+		assert.Equal(t, "on", msg.Variables["STATE"])
+		assert.Equal(t, "on", msg.Variables["payload.state"])
+	case <-time.After(time.Second):
+		t.Fatal("handler was not called")
+	}
+}
+
+func TestHandlerActionError(t *testing.T) {
+	client := newFakeClient()
+
+	h, err := NewHulk(client, "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.RegisterHandler("notify", HandlerFunc(func(ctx context.Context, msg *Message) error {
+		return context.Canceled
+	})))
+
+	manifest := Manifest{Topics: []string{"devices/+"}, Hooks: ManifestHooks{OnReceive: HookRules{{Handler: "notify"}}}}
+
+	assert.NoError(t, h.AddService("devices", manifest))
+	assert.NoError(t, h.LoadServices())
+
+	client.handlers["devices/+"]("devices/a1", nil)
+
+	// The handler runs in background, its error is recorded once it returns
+	errorMessage := func() string {
+		history := h.Services()[0].History
+		if len(history) == 0 || history[0].Running {
+			return ""
+		}
+
+		return history[0].Error
+	}
+
+	for start := time.Now(); errorMessage() == "" && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(t, "failed to execute OnReceiveHook: context canceled", errorMessage())
+}
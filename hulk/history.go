@@ -0,0 +1,83 @@
+package hulk
+
+import (
+	"time"
+
+	"github.com/OSSystems/hulk/api/types"
+)
+
+// maxHistorySize is the number of executions kept in the service history
+const maxHistorySize = 20
+
+// Filter results recorded in the execution history
+const (
+	filterMatched  = "matched"
+	filterRejected = "rejected"
+)
+
+// execution represents a message handled by a service
+type execution struct {
+	time    time.Time
+	topic   string
+	hook    HookName
+	rule    string
+	filter  string
+	running bool
+	err     error
+}
+
+// addExecution records an execution in the service history
+func (s *Service) addExecution(e *execution) {
+	s.historyLock.Lock()
+	defer s.historyLock.Unlock()
+
+	s.history = append(s.history, e)
+
+	if len(s.history) > maxHistorySize {
+		s.history = s.history[len(s.history)-maxHistorySize:]
+	}
+}
+
+// startExecution marks the execution as running
+func (s *Service) startExecution(e *execution) {
+	s.historyLock.Lock()
+	defer s.historyLock.Unlock()
+
+	e.running = true
+}
+
+// finishExecution records the result of a running execution
+func (s *Service) finishExecution(e *execution, err error) {
+	s.historyLock.Lock()
+	defer s.historyLock.Unlock()
+
+	e.running = false
+	e.err = err
+}
+
+// executions returns the service history as API types
+func (s *Service) executions() []*types.Execution {
+	s.historyLock.Lock()
+	defer s.historyLock.Unlock()
+
+	history := []*types.Execution{}
+
+	for _, e := range s.history {
+		entry := &types.Execution{
+			Time:    e.time,
+			Topic:   s.mask(e.topic),
+			Hook:    HookNameToString(e.hook),
+			Rule:    e.rule,
+			Filter:  e.filter,
+			Running: e.running,
+		}
+
+		if e.err != nil {
+			entry.Error = s.mask(e.err.Error())
+		}
+
+		history = append(history, entry)
+	}
+
+	return history
+}
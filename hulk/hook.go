@@ -1,11 +1,17 @@
 package hulk
 
 import (
-	"fmt"
-	"os/exec"
+	"context"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/OSSystems/hulk/filter"
+	"github.com/OSSystems/hulk/mqtt"
+	"github.com/OSSystems/hulk/template"
 	"github.com/OSSystems/pkg/log"
 	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
 )
 
 // HookName holds the supported hooks
@@ -20,91 +26,427 @@ var hookNames = map[HookName]string{
 	OnReceiveHook: "OnReceiveHook",
 }
 
+// defaultRetryDelay is the delay between attempts when the rule has no 'RetryDelay'
+const defaultRetryDelay = time.Second
+
 // Hook is the hook representation
 type Hook struct {
-	service *Service
-	name    HookName
-	topic   string
+	service  *Service
+	name     HookName
+	rule     *hookRule
+	topic    string
+	captures map[string]string
+
+	// environment and client are taken from the service when the hook is created,
+	// since the hook runs in background while the service may be reloaded
+	environment map[string]string
+	client      mqtt.MqttClient
 }
 
 // NewHook creates a new Hook instance
-func NewHook(service *Service, name HookName, topic string) *Hook {
+func NewHook(service *Service, name HookName, rule *hookRule, topic string, captures map[string]string) *Hook {
 	hook := &Hook{
-		service: service,
-		name:    name,
-		topic:   topic,
+		service:  service,
+		name:     name,
+		rule:     rule,
+		topic:    topic,
+		captures: captures,
+
+		environment: copyVariables(service.environment),
+		client:      service.hulk.client,
 	}
 
-	if hook.cmdLine() == "" {
+	if hook.action() == nil {
 		return nil
 	}
 
 	return hook
 }
 
-// cmdLine returns the cmd line of the hook
-func (h *Hook) cmdLine() string {
-	switch h.name {
-	case OnReceiveHook:
-		return h.service.manifest.Hooks.OnReceive
+// variables returns the variables available to the hook action, including the payload
+// variables referenced by the rule templates, which are not exported to hook commands
+func (h *Hook) variables(payload []byte) (map[string]string, error) {
+	variables := map[string]string{}
+
+	for key, value := range h.environment {
+		variables[key] = value
+	}
+
+	// Export named segments captured from topic, they never override the environment
+	// since the collisions are rejected when the topics are expanded
+	for name, value := range h.captures {
+		if _, ok := variables[name]; !ok {
+			variables[name] = value
+		}
+	}
+
+	variables["TOPIC"] = h.topic
+
+	for name, value := range payloadVariables(payload, h.rule.variables) {
+		variables[name] = value
 	}
 
-	return ""
+	// Rule inline variables are expanded with the hook variables available,
+	// but they must not override them
+	inline, err := h.rule.environment(variables)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range inline {
+		if _, ok := h.captures[key]; ok || key == "TOPIC" || isPayloadVariable(key) {
+			continue
+		}
+
+		variables[key] = value
+	}
+
+	return variables, nil
 }
 
-// createCmd creates command
-func (h *Hook) createCmd() *exec.Cmd {
-	args := []string{"-c"}
-	args = append(args, h.cmdLine())
+// execute executes the hook action in background, retrying it on failure,
+// and calls done with the result of the last attempt
+func (h *Hook) execute(payload []byte, done func(error)) error {
+	variables, err := h.variables(payload)
+	if err != nil {
+		return err
+	}
+
+	a := h.action()
+
+	logFields := h.logFields()
+	logFields["action"] = a.name()
+
+	log.WithFields(logFields).Info("executing hook")
 
-	cmd := exec.Command("sh", args...)
-	cmd.Env = []string{}
+	go func() {
+		done(h.run(a, variables, payload))
+	}()
 
-	for key, value := range h.service.environment {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	return nil
+}
+
+// run runs the hook action until it succeeds or the retries are exhausted,
+// each attempt is cancelled when the rule timeout expires
+func (h *Hook) run(a action, variables map[string]string, payload []byte) error {
+	delay := h.rule.RetryDelay
+	if delay == 0 {
+		delay = defaultRetryDelay
 	}
 
-	cmd.Env = append(cmd.Env, fmt.Sprintf("TOPIC=%s", h.topic))
+	var err error
+
+	for attempt := 0; attempt <= h.rule.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
 
-	return cmd
+			log.WithFields(h.logFields()).WithField("attempt", attempt+1).Info("retrying hook")
+		}
+
+		ctx, cancel := h.context()
+
+		// Each attempt gets its own copy since actions like handlers may change the variables
+		err = a.run(ctx, h, copyVariables(variables), payload)
+		if ctx.Err() == context.DeadlineExceeded {
+			err = errors.Errorf("hook timed out after %s", h.rule.Timeout)
+		}
+
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		log.WithFields(h.logFields()).WithField("attempt", attempt+1).Warn(h.mask(err.Error()))
+	}
+
+	return err
 }
 
-// execute executes hook command
-func (h *Hook) execute(payload []byte) error {
-	cmd := h.createCmd()
+// copyVariables returns a copy of variables
+func copyVariables(variables map[string]string) map[string]string {
+	copied := make(map[string]string, len(variables))
 
+	for key, value := range variables {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+// context returns the context of an attempt, which is cancelled when the rule timeout expires
+func (h *Hook) context() (context.Context, context.CancelFunc) {
+	if h.rule.Timeout > 0 {
+		return context.WithTimeout(context.Background(), h.rule.Timeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// mask masks the secret values of the hook environment found in str
+func (h *Hook) mask(str string) string {
+	return h.service.masker.String(str, h.environment)
+}
+
+// logFields returns the log fields identifying the hook
+func (h *Hook) logFields() logrus.Fields {
 	logFields := logrus.Fields{
 		"service": h.service.name,
 		"hook":    HookNameToString(h.name),
 	}
 
-	if log.GetLevel() == logrus.DebugLevel {
-		logFields["cmd"] = h.cmdLine()
-		logFields["env"] = cmd.Env
-		logFields["payload"] = string(payload)
-		log.WithFields(logFields).Debug("executing hook")
-	} else {
-		log.WithFields(logFields).Info("executing hook")
+	if h.rule.Name != "" {
+		logFields["rule"] = h.rule.Name
+	}
+
+	return logFields
+}
+
+// HookNameToString converts hook name to string
+func HookNameToString(name HookName) string {
+	return hookNames[name]
+}
+
+// hookRule is a HookRule with its filter, topic template, topic patterns and action templates compiled
+type hookRule struct {
+	HookRule
+
+	index    int
+	filter   *filter.Filter
+	topic    *template.Template
+	patterns []*topicPattern
+	// options are the service process options merged with the rule ones
+	options ProcessOptions
+	// templates holds the compiled action templates by content, see compileTemplates
+	templates map[string]*template.Template
+	// variables are the names of the variables referenced by the action templates
+	variables []string
+	// fileDirectory is the directory the expanded File Path is confined to, see staticDirectory
+	fileDirectory string
+}
+
+// newHookRule compiles the rule at index of a hook, options are the service process options
+func newHookRule(index int, rule HookRule, options ProcessOptions) (*hookRule, error) {
+	r := &hookRule{
+		HookRule: rule,
+		index:    index,
+		options:  options.merge(rule.ProcessOptions),
+	}
+
+	if err := validateAction(rule); err != nil {
+		return nil, err
+	}
+
+	if err := r.options.validate(); err != nil {
+		return nil, err
+	}
+
+	if rule.Payload != "" && rule.Payload != PayloadStdin && rule.Payload != PayloadFile {
+		return nil, errors.Errorf("invalid Payload: %s", rule.Payload)
+	}
+
+	if rule.Retries < 0 {
+		return nil, errors.Errorf("invalid Retries: %d", rule.Retries)
+	}
+
+	if rule.Filter != "" {
+		var err error
+		if r.filter, err = filter.Compile(rule.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	if rule.Topic != "" {
+		var err error
+		if r.topic, err = template.Compile(rule.Topic); err != nil {
+			return nil, errors.Wrap(err, "invalid Topic")
+		}
+	}
+
+	if err := r.compileTemplates(); err != nil {
+		return nil, err
+	}
+
+	// The file written and the URL requested must not be chosen by the message sender
+	if r.HTTP != nil {
+		if err := r.rejectPayloadVariables("HTTP URL", r.HTTP.URL); err != nil {
+			return nil, err
+		}
 	}
 
-	stdin, err := cmd.StdinPipe()
+	if r.File != nil {
+		if err := r.rejectPayloadVariables("File Path", r.File.Path); err != nil {
+			return nil, err
+		}
+
+		r.fileDirectory = staticDirectory(r.templates[r.File.Path])
+	}
+
+	return r, nil
+}
+
+// rejectPayloadVariables returns an error if the compiled template of content, from field,
+// references payload variables
+func (r *hookRule) rejectPayloadVariables(field, content string) error {
+	for _, variable := range r.templates[content].Variables() {
+		if isPayloadVariable(variable.Name) {
+			return errors.Errorf("%s cannot reference payload variable %s", field, variable.Name)
+		}
+	}
+
+	return nil
+}
+
+// compileTemplates compiles the templates expanded when running the rule action
+func (r *hookRule) compileTemplates() error {
+	r.templates = map[string]*template.Template{}
+	r.variables = []string{}
+
+	var err error
+
+	// compile compiles the templates of field until an error happens, fields expanded to a
+	// single string reject arrays since they would repeat the whole text for each element
+	compile := func(field string, arrays bool, templates ...string) {
+		for _, content := range templates {
+			if err != nil {
+				return
+			}
+
+			tpl, ok := r.templates[content]
+			if !ok {
+				var e error
+				if tpl, e = template.Compile(content); e != nil {
+					err = errors.Wrapf(e, "invalid %s", field)
+					return
+				}
+
+				r.templates[content] = tpl
+
+				for _, variable := range tpl.Variables() {
+					r.variables = append(r.variables, variable.Name)
+				}
+			}
+
+			for _, variable := range tpl.Variables() {
+				if variable.IsArray && !arrays {
+					err = errors.Errorf("%s cannot reference array variable %s", field, variable.Name)
+					return
+				}
+			}
+		}
+	}
+
+	compile("Command", true, commandTemplate(r.Command))
+	compile("Exec", true, r.Exec...)
+	compile("WorkingDirectory", false, r.options.WorkingDirectory)
+	compile("Environment", true, values(r.Environment)...)
+
+	if r.HTTP != nil {
+		compile("HTTP", false, append([]string{r.HTTP.URL, r.HTTP.Body}, values(r.HTTP.Headers)...)...)
+	}
+
+	if r.File != nil {
+		compile("File", false, r.File.Path)
+	}
+
+	if r.Publish != nil {
+		compile("Publish", false, r.Publish.Topic, r.Publish.Payload)
+	}
+
+	if r.Log != nil {
+		compile("Log", false, r.Log.Message)
+	}
+
+	return err
+}
+
+// template returns the compiled template of content
+func (r *hookRule) template(content string) (*template.Template, error) {
+	tpl, ok := r.templates[content]
+	if !ok {
+		return nil, errors.Errorf("template is not compiled: %s", content)
+	}
+
+	return tpl, nil
+}
+
+// expand expands the compiled template of content with variables
+func (r *hookRule) expand(content string, variables map[string]string) ([]string, error) {
+	tpl, err := r.template(content)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	expanded, err := tpl.Expand(variables)
+	if err != nil {
+		return nil, variableError(err)
 	}
 
-	defer stdin.Close()
+	return expanded, nil
+}
 
-	err = cmd.Start()
+// expandString expands the compiled template of content with variables, joining the array
+// values with spaces
+func (r *hookRule) expandString(content string, variables map[string]string) (string, error) {
+	expanded, err := r.expand(content, variables)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	stdin.Write(payload)
+	return strings.Join(expanded, " "), nil
+}
+
+// environment expands the rule inline variables with variables
+func (r *hookRule) environment(variables map[string]string) (map[string]string, error) {
+	expanded := map[string]string{}
 
-	return nil
+	for key, value := range r.Environment {
+		var err error
+		if expanded[key], err = r.expandString(value, variables); err != nil {
+			return nil, errors.Wrap(err, key)
+		}
+	}
+
+	return expanded, nil
 }
 
-// HookNameToString converts hook name to string
-func HookNameToString(name HookName) string {
-	return hookNames[name]
+// name returns the rule name or its index if the rule is unnamed
+func (r *hookRule) name() string {
+	return hookRuleName(r.index, r.HookRule)
+}
+
+// match matches the rule against a message and returns the captured segments
+func (r *hookRule) match(topic string, payload []byte) (map[string]string, bool) {
+	captures := map[string]string{}
+
+	if r.Topic != "" {
+		matched := false
+
+		for _, pattern := range r.patterns {
+			if values, ok := pattern.match(topic); ok {
+				captures = values
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return nil, false
+		}
+	}
+
+	if r.filter != nil && !r.filter.Match(topic, payload) {
+		return nil, false
+	}
+
+	return captures, true
+}
+
+// hookRuleName returns the rule name or its index if the rule is unnamed
+func hookRuleName(index int, rule HookRule) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+
+	return "#" + strconv.Itoa(index)
 }
@@ -0,0 +1,206 @@
+package hulk
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHookRuleTemplates(t *testing.T) {
+	testCases := []struct {
+		name              string
+		rule              HookRule
+		options           ProcessOptions
+		expectedVariables []string
+		expectedError     string
+	}{
+		{
+			"Command",
+			HookRule{Command: "echo {payload.name} ${HOME}", Environment: map[string]string{"NAME": "{DEVICE}"}},
+			ProcessOptions{},
+			[]string{"payload.name", "DEVICE"},
+			"",
+		},
+
+		{
+			"ServiceWorkingDirectory",
+			HookRule{Exec: []string{"ls"}},
+			ProcessOptions{WorkingDirectory: "/srv/{DEVICE}"},
+			[]string{"DEVICE"},
+			"",
+		},
+
+		{
+			"PayloadInFilePath",
+			HookRule{File: &FileAction{Path: "/var/lib/hulk/{payload.name}"}},
+			ProcessOptions{},
+			nil,
+			"File Path cannot reference payload variable payload.name",
+		},
+
+		{
+			"PayloadInURL",
+			HookRule{HTTP: &HTTPAction{URL: "http://{payload.host}/devices", Body: "{payload.name}"}},
+			ProcessOptions{},
+			nil,
+			"HTTP URL cannot reference payload variable payload.host",
+		},
+
+		{
+			"ArrayInPublish",
+			HookRule{Publish: &PublishAction{Topic: "devices/{DEVICES[,]}/reboot"}},
+			ProcessOptions{},
+			nil,
+			"Publish cannot reference array variable DEVICES",
+		},
+
+		{
+			"ArrayInWorkingDirectory",
+			HookRule{Exec: []string{"ls", "{DIRS[]}"}},
+			ProcessOptions{WorkingDirectory: "{DIRS[]}"},
+			nil,
+			"WorkingDirectory cannot reference array variable DIRS",
+		},
+
+		{
+			"InvalidCommand",
+			HookRule{Command: "echo {DEVICE|nosuch}"},
+			ProcessOptions{},
+			nil,
+			"invalid Command: template syntax error at position 5: unknown function: nosuch",
+		},
+
+		{
+			"InvalidHTTP",
+			HookRule{HTTP: &HTTPAction{URL: "http://localhost", Headers: map[string]string{"X-Device": "{DEVICE"}}},
+			ProcessOptions{},
+			nil,
+			"invalid HTTP: template syntax error at position 0: unterminated variable placeholder",
+		},
+
+		{
+			"InvalidLog",
+			HookRule{Log: &LogAction{Message: "{DEVICE[}"}},
+			ProcessOptions{},
+			nil,
+			"invalid Log: template syntax error at position 0: unterminated variable placeholder",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := newHookRule(0, tc.rule, tc.options)
+
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedVariables, rule.variables)
+		})
+	}
+}
+
+// newRuleHook returns a hook of a service running rule, with the PATH of the tests,
+// handlers are registered before the service is created
+func newRuleHook(t *testing.T, rule HookRule, handlers map[string]Handler) *Hook {
+	h, err := NewHulk(newFakeClient(), "")
+	assert.NoError(t, err)
+
+	for name, handler := range handlers {
+		assert.NoError(t, h.RegisterHandler(name, handler))
+	}
+
+	s, err := newService(h, "devices", Manifest{Topics: []string{"devices/+"}, Hooks: ManifestHooks{OnReceive: HookRules{rule}}})
+	assert.NoError(t, err)
+
+	s.environment["PATH"] = os.Getenv("PATH")
+
+	return NewHook(s, OnReceiveHook, s.rules[OnReceiveHook][0], "devices/1", nil)
+}
+
+func TestHookRun(t *testing.T) {
+	testCases := []struct {
+		name             string
+		retries          int
+		timeout          time.Duration
+		failures         int
+		expectedAttempts int
+		expectedError    string
+	}{
+		{"Succeeded", 2, 0, 0, 1, ""},
+		{"RetriedUntilSucceeded", 2, 0, 2, 3, ""},
+		{"RetriesExhausted", 1, 0, 5, 2, "attempt 2 failed"},
+		{"NoRetries", 0, 0, 1, 1, "attempt 1 failed"},
+		{"TimedOut", 1, 10 * time.Millisecond, 5, 2, "hook timed out after 10ms"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := []time.Time{}
+
+			handler := HandlerFunc(func(ctx context.Context, msg *Message) error {
+				attempts = append(attempts, time.Now())
+
+				if len(attempts) > tc.failures {
+					return nil
+				}
+
+				// A timed out attempt blocks until it is cancelled
+				if tc.timeout > 0 {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+
+				return errors.Errorf("attempt %d failed", len(attempts))
+			})
+
+			rule := HookRule{Handler: "handler", Retries: tc.retries, RetryDelay: 20 * time.Millisecond, Timeout: tc.timeout}
+			h := newRuleHook(t, rule, map[string]Handler{"handler": handler})
+
+			err := h.run(h.action(), map[string]string{}, nil)
+
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedError)
+			}
+
+			assert.Len(t, attempts, tc.expectedAttempts)
+
+			// Attempts are separated by the retry delay
+			for i := 1; i < len(attempts); i++ {
+				assert.True(t, attempts[i].Sub(attempts[i-1]) >= rule.RetryDelay)
+			}
+		})
+	}
+}
+
+func TestHookExecute(t *testing.T) {
+	handler := HandlerFunc(func(ctx context.Context, msg *Message) error {
+		return errors.New("failed")
+	})
+
+	h := newRuleHook(t, HookRule{Handler: "handler", Environment: map[string]string{"NAME": "{MISSING}"}}, map[string]Handler{"handler": handler})
+
+	// The variables are expanded before running the hook in background
+	err := h.execute(nil, func(error) { t.Fatal("hook must not run") })
+	assert.EqualError(t, err, "NAME: No value for required variable: MISSING")
+
+	h = newRuleHook(t, HookRule{Handler: "handler"}, map[string]Handler{"handler": handler})
+
+	done := make(chan error, 1)
+	assert.NoError(t, h.execute(nil, func(err error) { done <- err }))
+
+	select {
+	case err := <-done:
+		assert.EqualError(t, err, "failed")
+	case <-time.After(time.Second):
+		t.Fatal("hook did not finish")
+	}
+}
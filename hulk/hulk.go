@@ -4,32 +4,90 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/OSSystems/hulk/api/types"
-	"github.com/OSSystems/pkg/log"
 	"github.com/OSSystems/hulk/mqtt"
 	"github.com/OSSystems/hulk/pkg/filewatcher"
+	"github.com/OSSystems/hulk/pkg/secret"
+	"github.com/OSSystems/pkg/log"
 	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
 )
 
+// ErrServiceNotFound is returned when there is no service with the given name
+var ErrServiceNotFound = errors.New("service not found")
+
 // Hulk represents a Hulk instance
 type Hulk struct {
+	// lock serializes the changes of services and subscriptions
+	lock sync.Mutex
+
 	path     string
 	services []*Service
 	client   mqtt.MqttClient
 	handlers map[string][]*Service
 	fwatcher *filewatcher.FileWatcher
+	// messages queues the received messages, which are handled in order by dispatch
+	messages chan *message
+
+	// environmentFile is the daemon default environment file
+	environmentFile string
+	environment     map[string]string
+	// debounce is the debounce window of the watched environment files
+	debounce time.Duration
+	// removedFiles holds the environment files removed while hulk is running,
+	// they are kept across reloads
+	removedFiles map[string]bool
+
+	// masker masks secret values in logs and API output
+	masker *secret.Masker
+
+	// registry holds the handlers registered by name
+	registry map[string]Handler
+	// definitions holds the services added programmatically, they are kept across reloads
+	definitions []*serviceDefinition
+	// loaded tells whether services were loaded
+	loaded bool
+
+	// stateFile persists the administratively disabled services
+	stateFile string
+	disabled  map[string]bool
+}
+
+// messageQueueSize is the number of received messages which may wait to be handled
+const messageQueueSize = 1024
+
+// message represents a received message
+type message struct {
+	// subscription is the subscribed topic, which may differ from the received topic
+	// when it contains wildcards
+	subscription string
+	topic        string
+	payload      []byte
+}
+
+// serviceDefinition represents a service added programmatically
+type serviceDefinition struct {
+	name     string
+	manifest Manifest
 }
 
 // NewHulk initializes a new Hulk instance
+//
+// Services are loaded from manifest files in path, an empty path means
+// that services are only added programmatically by AddService.
 func NewHulk(client mqtt.MqttClient, path string) (*Hulk, error) {
-	stat, err := os.Stat(path)
-	if err != nil {
-		return nil, err
-	}
+	if path != "" {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
 
-	if !stat.IsDir() {
-		return nil, fmt.Errorf("%s: not a directory", path)
+		if !stat.IsDir() {
+			return nil, fmt.Errorf("%s: not a directory", path)
+		}
 	}
 
 	fwatcher, err := filewatcher.NewFileWatcher()
@@ -37,35 +95,82 @@ func NewHulk(client mqtt.MqttClient, path string) (*Hulk, error) {
 		return nil, err
 	}
 
-	return &Hulk{
-		client:   client,
-		handlers: make(map[string][]*Service),
-		path:     path,
-		fwatcher: fwatcher,
-	}, nil
+	h := &Hulk{
+		client:       client,
+		handlers:     make(map[string][]*Service),
+		path:         path,
+		fwatcher:     fwatcher,
+		messages:     make(chan *message, messageQueueSize),
+		environment:  make(map[string]string),
+		debounce:     filewatcher.DefaultDebounce,
+		removedFiles: make(map[string]bool),
+		masker:       secret.NewMasker(secret.DefaultPatterns...),
+		registry:     make(map[string]Handler),
+		disabled:     make(map[string]bool),
+	}
+
+	go h.dispatch()
+
+	return h, nil
 }
 
-// LoadServices loads services from a predefined directory
+// LoadServices loads services from a predefined directory and the services added programmatically
 func (h *Hulk) LoadServices() error {
-	files, err := filepath.Glob(filepath.Join(h.path, "*.yaml"))
-	if err != nil {
-		return err
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.loadServices()
+}
+
+// loadServices loads services, hulk must be locked
+func (h *Hulk) loadServices() error {
+	files := []string{}
+
+	if h.path != "" {
+		var err error
+		if files, err = filepath.Glob(filepath.Join(h.path, "*.yaml")); err != nil {
+			return err
+		}
 	}
 
+	services := []*Service{}
+
 	for _, file := range files {
 		service, err := NewService(h, file)
 		if err != nil {
 			log.Warn(err)
+
+			// Keep the service listed so the manifest error can be reported
+			service = newBrokenService(h, file, err)
+		}
+
+		services = append(services, service)
+	}
+
+	for _, definition := range h.definitions {
+		if serviceByName(services, definition.name) != nil {
+			log.WithFields(logrus.Fields{"service": definition.name}).Warn("service already exists")
+			continue
+		}
+
+		service, err := newService(h, definition.name, definition.manifest)
+		if err != nil {
+			log.Warn(errors.Wrap(err, definition.name))
 			continue
 		}
 
-		service.enabled = h.client.IsConnected()
+		services = append(services, service)
+	}
+
+	services, cycles := sortServices(services)
+	logCycles(cycles)
 
-		h.addService(service)
+	for _, service := range services {
+		if cycle := serviceCycle(cycles, service.name); cycle != nil {
+			service.failure = cycleStatus(cycle)
+		}
 
-		// Prepare service for subscription
-		service.loadEnvironment()
-		service.expandTopics()
+		h.prepareService(service)
 	}
 
 	// Subscribe to all services topics
@@ -73,10 +178,152 @@ func (h *Hulk) LoadServices() error {
 		service.subscribe()
 	}
 
+	h.loaded = true
+
+	return nil
+}
+
+// AddService adds a service built from manifest instead of a manifest file
+//
+// The service is started by LoadServices, or right away if services were already loaded.
+func (h *Hulk) AddService(name string, manifest Manifest) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if name == "" {
+		return errors.New("service name is empty")
+	}
+
+	for _, definition := range h.definitions {
+		if definition.name == name {
+			return errors.Errorf("service %s already exists", name)
+		}
+	}
+
+	if h.service(name) != nil {
+		return errors.Errorf("service %s already exists", name)
+	}
+
+	service, err := newService(h, name, manifest)
+	if err != nil {
+		return errors.Wrap(err, name)
+	}
+
+	if h.loaded {
+		if _, cycles := sortServices(append(append([]*Service{}, h.services...), service)); serviceCycle(cycles, name) != nil {
+			logCycles(cycles)
+			return errors.Errorf("service %s has a dependency cycle", name)
+		}
+	}
+
+	h.definitions = append(h.definitions, &serviceDefinition{name: name, manifest: manifest})
+
+	if h.loaded {
+		h.prepareService(service)
+		service.subscribe()
+
+		// Services requiring the new service were waiting for it
+		h.reloadDependents(service)
+	}
+
+	return nil
+}
+
+// prepareService adds service to managed services and prepares it for subscription
+func (h *Hulk) prepareService(service *Service) {
+	service.enabled = h.canEnable(service)
+
+	h.addService(service)
+
+	service.loadEnvironment()
+	service.expandTopics()
+}
+
+// service returns the managed service named name or nil if there is none
+func (h *Hulk) service(name string) *Service {
+	return serviceByName(h.services, name)
+}
+
+// canEnable tells whether service can be enabled, that is, it was loaded, it is not
+// administratively disabled, its environment files were not removed, the broker is
+// connected and the services it requires are enabled, otherwise the reason is set
+// in the service status
+func (h *Hulk) canEnable(service *Service) bool {
+	if service.failure != nil {
+		service.status = service.failure
+		return false
+	}
+
+	if h.disabled[service.name] {
+		log.WithFields(logrus.Fields{"service": service.name}).Info("service is administratively disabled")
+
+		service.status = adminDisabledStatus
+		return false
+	}
+
+	if file := service.removedEnvironmentFile(); file != "" {
+		log.WithFields(logrus.Fields{
+			"service": service.name,
+			"file":    file,
+		}).Warn("environment file removed, service disabled")
+
+		service.status = &types.ServiceStatus{
+			Reason:  types.StatusReasonEnvironmentFileRemoved,
+			Message: fmt.Sprintf("environment file %s was removed", file),
+			File:    file,
+		}
+
+		return false
+	}
+
+	if !h.client.IsConnected() {
+		service.status = &types.ServiceStatus{
+			Reason:  types.StatusReasonBrokerDisconnected,
+			Message: "broker disconnected",
+		}
+
+		return false
+	}
+
+	if !h.dependenciesEnabled(service) {
+		return false
+	}
+
+	service.status = nil
+
+	return true
+}
+
+// adminDisabledStatus is the status of administratively disabled services
+var adminDisabledStatus = &types.ServiceStatus{
+	Reason:  types.StatusReasonAdminDisabled,
+	Message: "administratively disabled",
+}
+
+// serviceByName returns the service named name in services or nil if there is none
+func serviceByName(services []*Service, name string) *Service {
+	for _, service := range services {
+		if service.name == name {
+			return service
+		}
+	}
+
 	return nil
 }
 
+// SetSecretPatterns adds name patterns of secret variables to the default ones
+func (h *Hulk) SetSecretPatterns(patterns ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.masker = secret.NewMasker(secret.DefaultPatterns...).With(patterns...)
+}
+
+// Services returns the managed services with secret values masked
 func (h *Hulk) Services() []*types.Service {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
 	services := []*types.Service{}
 
 	for _, service := range h.services {
@@ -84,10 +331,22 @@ func (h *Hulk) Services() []*types.Service {
 			Name:        service.name,
 			Description: service.manifest.Description,
 			Enabled:     service.enabled,
-			Topics:      service.topics,
+			Status:      service.status,
+			Requires:    service.manifest.Requires,
+			After:       service.manifest.After,
+			Topics:      service.maskStrings(service.topics),
+			Variables:   service.variables(),
+			Filter:      service.mask(service.manifest.Filter),
+			Environment: service.masker.Environment(service.environment),
+			Process:     service.manifest.ProcessOptions.toAPI(),
+			History:     service.executions(),
 		}
 
-		s.Hooks.OnReceive = service.manifest.Hooks.OnReceive
+		s.Hooks.OnReceiveMode = service.manifest.Hooks.OnReceiveMode
+
+		for _, rule := range service.manifest.Hooks.OnReceive {
+			s.Hooks.OnReceive = append(s.Hooks.OnReceive, service.hookRuleToAPI(rule))
+		}
 
 		services = append(services, s)
 	}
@@ -95,7 +354,11 @@ func (h *Hulk) Services() []*types.Service {
 	return services
 }
 
+// Reload reloads all services using client
 func (h *Hulk) Reload(client mqtt.MqttClient) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
 	log.Debug("reloading hulk")
 
 	for topic, services := range h.handlers {
@@ -108,7 +371,120 @@ func (h *Hulk) Reload(client mqtt.MqttClient) error {
 
 	h.client = client
 
-	return h.LoadServices()
+	return h.loadServices()
+}
+
+// EnableService enables an administratively disabled service
+func (h *Hulk) EnableService(name string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	service := h.service(name)
+	if service == nil {
+		return ErrServiceNotFound
+	}
+
+	delete(h.disabled, name)
+
+	if err := h.saveState(); err != nil {
+		h.disabled[name] = true
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"service": name}).Info("service enabled")
+
+	h.reloadService(service)
+	h.reloadDependents(service)
+
+	return nil
+}
+
+// DisableService administratively disables a service, it stays disabled across restarts
+func (h *Hulk) DisableService(name string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	service := h.service(name)
+	if service == nil {
+		return ErrServiceNotFound
+	}
+
+	h.disabled[name] = true
+
+	if err := h.saveState(); err != nil {
+		delete(h.disabled, name)
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"service": name}).Info("service disabled")
+
+	service.enabled = false
+	service.status = adminDisabledStatus
+	service.unsubscribe()
+
+	h.reloadDependents(service)
+
+	return nil
+}
+
+// ReloadService reloads a service manifest and environment, restarting the service
+func (h *Hulk) ReloadService(name string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	service := h.service(name)
+	if service == nil {
+		return ErrServiceNotFound
+	}
+
+	// Services added programmatically have no manifest file to reload
+	if service.file == "" {
+		h.reloadService(service)
+		h.reloadDependents(service)
+
+		return nil
+	}
+
+	reloaded, err := NewService(h, service.file)
+	if err != nil {
+		return err
+	}
+
+	services := []*Service{}
+	for _, s := range h.services {
+		if s == service {
+			s = reloaded
+		}
+
+		services = append(services, s)
+	}
+
+	sorted, cycles := sortServices(services)
+	if serviceCycle(cycles, name) != nil {
+		logCycles(cycles)
+		return errors.Errorf("service %s has a dependency cycle", name)
+	}
+
+	service.enabled = false
+	service.unsubscribe()
+
+	h.services = sorted
+	h.watchEnvironmentFiles(reloaded)
+
+	h.reloadService(reloaded)
+	h.reloadDependents(reloaded)
+
+	// The reloaded service may have broken a dependency cycle
+	for _, s := range h.services {
+		if s.failure != nil && s.failure.Reason == types.StatusReasonDependencyCycle && serviceCycle(cycles, s.name) == nil {
+			s.failure = nil
+
+			h.reloadService(s)
+			h.reloadDependents(s)
+		}
+	}
+
+	return nil
 }
 
 // addService adds service to managed services by Hulk
@@ -117,7 +493,11 @@ func (h *Hulk) addService(service *Service) {
 
 	log.WithFields(logrus.Fields{"service": service.name}).Info("service added")
 
-	// Watch environment files for changes
+	h.watchEnvironmentFiles(service)
+}
+
+// watchEnvironmentFiles watches the service environment files for changes
+func (h *Hulk) watchEnvironmentFiles(service *Service) {
 	for _, file := range service.manifest.EnvironmentFiles {
 		if _, err := os.Stat(file); os.IsNotExist(err) {
 			log.WithFields(logrus.Fields{
@@ -126,7 +506,7 @@ func (h *Hulk) addService(service *Service) {
 			}).Warn("environment file does not exists")
 		}
 
-		err := h.fwatcher.Add(file)
+		err := h.fwatcher.AddDebounced(file, h.debounce)
 		if err != nil {
 			log.Warn(err)
 		}
@@ -135,9 +515,14 @@ func (h *Hulk) addService(service *Service) {
 
 // subscribe subscribes to service topics
 func (h *Hulk) subscribe(topic string, service *Service) error {
-	callback := func(topic string, payload []byte) {
-		for _, s := range h.handlers[topic] {
-			s.messageHandler(topic, payload)
+	callback := func(received string, payload []byte) {
+		// Queue the message instead of handling it right away, since the client may deliver it
+		// while hulk is locked waiting for a subscription to complete, for the same reason
+		// the message is dropped instead of blocking the client when the queue is full
+		select {
+		case h.messages <- &message{subscription: topic, topic: received, payload: payload}:
+		default:
+			log.WithFields(logrus.Fields{"topic": received}).Warn("message queue is full, message dropped")
 		}
 	}
 
@@ -146,12 +531,27 @@ func (h *Hulk) subscribe(topic string, service *Service) error {
 	return h.client.Subscribe(topic, 0, callback)
 }
 
-// unsubscribe unsubscribes service from topic
+// dispatch handles the queued messages in the order they were received
+func (h *Hulk) dispatch() {
+	for m := range h.messages {
+		h.lock.Lock()
+
+		for _, s := range h.handlers[m.subscription] {
+			s.messageHandler(m.topic, m.payload)
+		}
+
+		h.lock.Unlock()
+	}
+}
+
+// unsubscribe unsubscribes service from topic, a service subscribed to the same topic
+// more than once is removed only once, like subscribe adds it once
 func (h *Hulk) unsubscribe(topic string, service *Service) {
 	for i, s := range h.handlers[topic] {
 		// Remove service handler
 		if service == s {
 			h.handlers[topic] = append(h.handlers[topic][:i], h.handlers[topic][i+1:]...)
+			break
 		}
 	}
 
@@ -162,20 +562,62 @@ func (h *Hulk) unsubscribe(topic string, service *Service) {
 	}
 }
 
-// reloadServices reloads services which depends on environment file
-func (h *Hulk) reloadServices(file string) {
+// reloadServices reloads services which depends on the environment file of event
+// along with the services requiring them, services are disabled while one of their
+// environment files is removed
+func (h *Hulk) reloadServices(event filewatcher.Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	affected := map[*Service]bool{}
+
+	// All services depend on the daemon default environment file
+	if event.Name == h.environmentFile {
+		h.loadEnvironment()
+
+		for _, service := range h.services {
+			affected[service] = true
+		}
+	}
+
 	for _, service := range h.services {
 		for _, envfile := range service.manifest.EnvironmentFiles {
-			if envfile == file {
-				log.WithFields(logrus.Fields{"service": service.name}).Info("reloading service")
-
-				service.enabled = true
-				service.loadEnvironment()
-				service.expandTopics()
-				service.subscribe()
+			if envfile != event.Name {
+				continue
 			}
+
+			affected[service] = true
 		}
 	}
+
+	if event.Type == filewatcher.Removed {
+		h.removedFiles[event.Name] = true
+	} else {
+		delete(h.removedFiles, event.Name)
+	}
+
+	h.reloadAffected(affected)
+}
+
+// reloadService reloads service environment and, if the service was enabled or disabled
+// or the variables referenced by its topics changed, expands its topics again,
+// subscribing only to the new topics and unsubscribing only from the removed ones
+func (h *Hulk) reloadService(service *Service) {
+	enabled := service.enabled
+	subscribed := service.subscribedTopics()
+
+	service.enabled = h.canEnable(service)
+	service.loadEnvironment()
+
+	if service.enabled == enabled && !service.topicVariablesChanged() {
+		log.WithFields(logrus.Fields{"service": service.name}).Debug("service topics unchanged")
+		return
+	}
+
+	log.WithFields(logrus.Fields{"service": service.name}).Info("reloading service")
+
+	service.expandTopics()
+	service.updateSubscriptions(subscribed)
 }
 
 // Run runs the Hulk main loop
@@ -187,9 +629,13 @@ func (h *Hulk) Run() {
 	go func() {
 		for {
 			select {
-			case file := <-h.fwatcher.Changed:
-				log.WithFields(logrus.Fields{"file": file}).Debug("environment file changed")
-				h.reloadServices(file)
+			case event := <-h.fwatcher.Events:
+				log.WithFields(logrus.Fields{
+					"file":  event.Name,
+					"event": event.Type,
+				}).Debug("environment file changed")
+
+				h.reloadServices(event)
 			}
 		}
 	}()
@@ -0,0 +1,190 @@
+package hulk
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/OSSystems/hulk/api/types"
+	"github.com/OSSystems/hulk/pkg/filewatcher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageOrder(t *testing.T) {
+	client := newFakeClient()
+
+	h, err := NewHulk(client, "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.AddService("devices", Manifest{Topics: []string{"devices/+"}}))
+	assert.NoError(t, h.LoadServices())
+
+	expected := []string{}
+
+	for i := 0; i < maxHistorySize; i++ {
+		topic := "devices/" + strconv.Itoa(i)
+		expected = append(expected, topic)
+
+		client.handlers["devices/+"](topic, nil)
+	}
+
+	topics := func() []string {
+		topics := []string{}
+		for _, e := range h.Services()[0].History {
+			topics = append(topics, e.Topic)
+		}
+
+		return topics
+	}
+
+	// The messages are handled in background
+	for start := time.Now(); len(topics()) < maxHistorySize && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(t, expected, topics())
+}
+
+func TestReloadBrokerDisconnected(t *testing.T) {
+	client := newFakeClient()
+	client.disconnected = true
+
+	h, err := NewHulk(client, "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.AddService("devices", Manifest{Topics: []string{"devices/+"}}))
+	assert.NoError(t, h.LoadServices())
+
+	service := h.service("devices")
+	assert.False(t, service.enabled)
+	assert.Equal(t, types.StatusReasonBrokerDisconnected, service.status.Reason)
+
+	// A reload must not enable the service while the broker is disconnected
+	assert.NoError(t, h.ReloadService("devices"))
+	assert.False(t, service.enabled)
+	assert.Equal(t, types.StatusReasonBrokerDisconnected, service.status.Reason)
+	assert.Empty(t, client.calls)
+
+	client.disconnected = false
+
+	assert.NoError(t, h.ReloadService("devices"))
+	assert.True(t, service.enabled)
+	assert.Nil(t, service.status)
+	assert.Equal(t, []string{"subscribe devices/+"}, client.calls)
+}
+
+func TestReloadRemovedEnvironmentFile(t *testing.T) {
+	h, err := NewHulk(newFakeClient(), "")
+	assert.NoError(t, err)
+
+	file := "/nonexistent/devices.env"
+	manifest := Manifest{Topics: []string{"devices/+"}, EnvironmentFiles: []string{file}}
+
+	assert.NoError(t, h.AddService("devices", manifest))
+	assert.NoError(t, h.LoadServices())
+	assert.True(t, h.service("devices").enabled)
+
+	h.reloadServices(filewatcher.Event{Name: file, Type: filewatcher.Removed})
+	assert.False(t, h.service("devices").enabled)
+
+	// The removed file is remembered when the services are created again
+	assert.NoError(t, h.Reload(h.client))
+
+	service := h.service("devices")
+	assert.False(t, service.enabled)
+	assert.Equal(t, types.StatusReasonEnvironmentFileRemoved, service.status.Reason)
+	assert.Equal(t, file, service.status.File)
+
+	h.reloadServices(filewatcher.Event{Name: file, Type: filewatcher.Created})
+	assert.True(t, h.service("devices").enabled)
+}
+
+func TestLoadBrokenManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	manifests := map[string]string{
+		"devices": "Topics:\n  - devices/+\nHooks:\n  OnReceive:\n    - Command: echo\n",
+		"syntax":  "Topics:\n  - devices/+\nHooks: [\n",
+		"unknown": "Topics:\n  - devices/+\nHooks:\n  OnReceive:\n    - Command: echo\n      Retry: 3\n",
+		"rule":    "Topics:\n  - devices/+\nHooks:\n  OnReceive:\n    - Command: echo\n      Retries: -1\n",
+	}
+
+	for name, content := range manifests {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".yaml"), []byte(content), 0644))
+	}
+
+	h, err := NewHulk(newFakeClient(), dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.LoadServices())
+
+	testCases := []struct {
+		name           string
+		expectedLine   int
+		expectedColumn int
+		expectedError  string
+	}{
+		{"syntax", 3, 0, "did not find expected node content"},
+		{"unknown", 6, 7, "unknown key Retry"},
+		{"rule", 0, 0, "invalid OnReceive rule #0: invalid Retries: -1"},
+	}
+
+	services := map[string]*types.Service{}
+	for _, service := range h.Services() {
+		services[service.Name] = service
+	}
+
+	assert.True(t, services["devices"].Enabled)
+	assert.Nil(t, services["devices"].Status)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := services[tc.name]
+			file := filepath.Join(dir, tc.name+".yaml")
+
+			err := &ManifestError{File: file, Line: tc.expectedLine, Column: tc.expectedColumn, Err: errors.New(tc.expectedError)}
+
+			assert.False(t, service.Enabled)
+			assert.Equal(t, &types.ServiceStatus{
+				Reason:  types.StatusReasonManifestError,
+				Message: err.Error(),
+				File:    file,
+				Line:    tc.expectedLine,
+				Column:  tc.expectedColumn,
+			}, service.Status)
+		})
+	}
+}
+
+func TestAddServiceAfterLoad(t *testing.T) {
+	client := newFakeClient()
+
+	h, err := NewHulk(client, "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.AddService("devices", Manifest{Topics: []string{"devices/+"}}))
+	assert.NoError(t, h.LoadServices())
+
+	client.calls = nil
+
+	assert.EqualError(t, h.AddService("devices", Manifest{Topics: []string{"other/+"}}), "service devices already exists")
+	assert.Empty(t, client.calls)
+
+	assert.NoError(t, h.AddService("sensors", Manifest{Topics: []string{"sensors/+"}, Requires: []string{"devices"}}))
+	assert.Equal(t, []string{"subscribe sensors/+"}, client.calls)
+	assert.Equal(t, map[string]bool{"devices": true, "sensors": true}, enabledServices(h))
+
+	// The new service is kept across reloads
+	client.calls = nil
+
+	assert.NoError(t, h.Reload(client))
+	assert.Equal(t, map[string]bool{"devices": true, "sensors": true}, enabledServices(h))
+	assert.Contains(t, client.calls, "subscribe sensors/+")
+}
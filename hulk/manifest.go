@@ -1,27 +1,252 @@
 package hulk
 
-import yaml "gopkg.in/yaml.v2"
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Hook rule matching modes
+const (
+	// HookModeFirst executes only the first matching rule
+	HookModeFirst = "first"
+	// HookModeAll executes all matching rules
+	HookModeAll = "all"
+)
 
 // Manifest represents a service manifest
 type Manifest struct {
-	Description      string        `yaml:"Description,omitempty"`
-	Topics           []string      `yaml:"Topics"`
-	EnvironmentFiles []string      `yaml:"EnvironmentFiles,omitempty"`
-	Hooks            ManifestHooks `yaml:"Hooks,omitempty"`
+	Description      string            `yaml:"Description,omitempty"`
+	Requires         []string          `yaml:"Requires,omitempty"`
+	After            []string          `yaml:"After,omitempty"`
+	Topics           []string          `yaml:"Topics"`
+	EnvironmentFiles []string          `yaml:"EnvironmentFiles,omitempty"`
+	PassEnvironment  []string          `yaml:"PassEnvironment,omitempty"`
+	Environment      map[string]string `yaml:"Environment,omitempty"`
+	SecretVariables  []string          `yaml:"SecretVariables,omitempty"`
+	Filter           string            `yaml:"Filter,omitempty"`
+	Hooks            ManifestHooks     `yaml:"Hooks,omitempty"`
+
+	// Default process attributes of the service hooks
+	ProcessOptions `yaml:",inline"`
 }
 
 // ManifestHooks represents the 'Hooks' section of a service manifest
 type ManifestHooks struct {
-	OnReceive string `yaml:"OnReceive,omitempty"`
+	OnReceive     HookRules `yaml:"OnReceive,omitempty"`
+	OnReceiveMode string    `yaml:"OnReceiveMode,omitempty"`
+}
+
+// HookRules represents a list of hook rules
+//
+// For backward compatibility it can be written as a single command string.
+type HookRules []HookRule
+
+// HookRule represents an action executed when the received message matches its topic and filter
+//
+// The action is either a 'Command' string run by 'sh -c', an 'Exec' argv list
+// run directly, each element of 'Exec' is template expanded with the hook variables,
+// one of the built-in 'HTTP', 'File', 'Publish' and 'Log' actions, or the name of
+// a 'Handler' registered by the program embedding hulk.
+//
+// The placeholders of 'Command' are replaced by references to environment variables
+// holding their values, so quote them like shell parameters, as in "{payload.name}",
+// array placeholders expand in place to a word for each element, placeholders of
+// variables without value and shell expansions like ${VAR} are left to the shell.
+// Array placeholders are only supported in 'Command', 'Exec' and 'Environment'.
+// Templates may also reference the payload as {payload} and its JSON fields as
+// {payload.a.b}, these variables are not exported to the command environment.
+type HookRule struct {
+	Name        string            `yaml:"Name,omitempty"`
+	Topic       string            `yaml:"Topic,omitempty"`
+	Filter      string            `yaml:"Filter,omitempty"`
+	Command     string            `yaml:"Command,omitempty"`
+	Exec        []string          `yaml:"Exec,omitempty"`
+	HTTP        *HTTPAction       `yaml:"HTTP,omitempty"`
+	File        *FileAction       `yaml:"File,omitempty"`
+	Publish     *PublishAction    `yaml:"Publish,omitempty"`
+	Log         *LogAction        `yaml:"Log,omitempty"`
+	Handler     string            `yaml:"Handler,omitempty"`
+	Timeout     time.Duration     `yaml:"Timeout,omitempty"`
+	Retries     int               `yaml:"Retries,omitempty"`
+	RetryDelay  time.Duration     `yaml:"RetryDelay,omitempty"`
+	Payload     string            `yaml:"Payload,omitempty"`
+	Environment map[string]string `yaml:"Environment,omitempty"`
+
+	// Process attributes overriding the service defaults
+	ProcessOptions `yaml:",inline"`
+}
+
+// HTTPAction represents the built-in action which sends an HTTP request,
+// the received payload is sent when 'Body' is empty, 'URL' cannot reference payload variables
+type HTTPAction struct {
+	Method  string            `yaml:"Method,omitempty"`
+	URL     string            `yaml:"URL"`
+	Headers map[string]string `yaml:"Headers,omitempty"`
+	Body    string            `yaml:"Body,omitempty"`
+}
+
+// FileAction represents the built-in action which writes the received payload to a file
+//
+// 'Path' cannot reference payload variables and the expanded path must stay inside the
+// directory written before its first placeholder. The file is written as the rule User
+// and Group if any, 'Mode' applies to new files only.
+type FileAction struct {
+	Path   string `yaml:"Path"`
+	Append bool   `yaml:"Append,omitempty"`
+	Mode   string `yaml:"Mode,omitempty"`
+}
+
+// PublishAction represents the built-in action which publishes a message,
+// the received payload is republished when 'Payload' is empty
+type PublishAction struct {
+	Topic   string `yaml:"Topic"`
+	Payload string `yaml:"Payload,omitempty"`
+	QoS     byte   `yaml:"QoS,omitempty"`
+	Retain  bool   `yaml:"Retain,omitempty"`
+}
+
+// LogAction represents the built-in action which writes a message to hulkd log
+type LogAction struct {
+	Level   string `yaml:"Level,omitempty"`
+	Message string `yaml:"Message"`
 }
 
-// LoadManifest loads manifest from data
+// UnmarshalYAML implements yaml.Unmarshaler interface
+func (r *HookRules) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var command string
+	if err := unmarshal(&command); err == nil {
+		*r = HookRules{}
+
+		if command != "" {
+			*r = append(*r, HookRule{Command: command})
+		}
+
+		return nil
+	}
+
+	var rules []HookRule
+	if err := unmarshal(&rules); err != nil {
+		return err
+	}
+
+	*r = HookRules(rules)
+
+	return nil
+}
+
+// LoadManifest loads manifest from data, unknown keys are rejected
 func LoadManifest(data []byte) (Manifest, error) {
 	manifest := Manifest{}
 
-	if err := yaml.Unmarshal(data, &manifest); err != nil {
+	if err := yaml.UnmarshalStrict(data, &manifest); err != nil {
 		return manifest, err
 	}
 
+	if manifest.Hooks.OnReceiveMode == "" {
+		manifest.Hooks.OnReceiveMode = HookModeFirst
+	}
+
 	return manifest, nil
 }
+
+// ManifestError represents an error loading a manifest file
+type ManifestError struct {
+	File string
+	// Line and Column locate the error in the file, they are zero if unknown
+	Line   int
+	Column int
+	Err    error
+}
+
+// Error returns a string representation of a ManifestError
+func (e *ManifestError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Err)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s", e.File, e.Err)
+}
+
+// Cause returns the underlying error
+func (e *ManifestError) Cause() error {
+	return e.Err
+}
+
+var (
+	// yamlLineRegexp matches the line of yaml syntax errors
+	yamlLineRegexp = regexp.MustCompile("^yaml: line ([0-9]+): (.*)$")
+	// yamlTypeRegexp matches the line and value of yaml unmarshal errors
+	yamlTypeRegexp = regexp.MustCompile("^line ([0-9]+): (cannot unmarshal .* `(.*)` into .*)$")
+	// yamlFieldRegexp matches the line and key of yaml unknown key errors
+	yamlFieldRegexp = regexp.MustCompile("^line ([0-9]+): field (.*) not found in type .*$")
+)
+
+// newManifestError creates a ManifestError locating the yaml error err of file with content data
+func newManifestError(file string, data []byte, err error) *ManifestError {
+	e := &ManifestError{File: file, Err: err}
+
+	if m := yamlLineRegexp.FindStringSubmatch(err.Error()); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+		e.Err = fmt.Errorf("%s", m[2])
+	}
+
+	// Only the first unmarshal error is located, the others are kept in the message
+	if te, ok := err.(*yaml.TypeError); ok && len(te.Errors) > 0 {
+		errs := append([]string{}, te.Errors...)
+
+		if m := yamlTypeRegexp.FindStringSubmatch(errs[0]); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Column = valueColumn(data, e.Line, m[3])
+			errs[0] = m[2]
+		} else if m := yamlFieldRegexp.FindStringSubmatch(errs[0]); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Column = keyColumn(data, e.Line, m[2])
+			errs[0] = "unknown key " + m[2]
+		}
+
+		e.Err = fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return e
+}
+
+// keyColumn returns the column of key in line of data or zero if it is not found
+func keyColumn(data []byte, line int, key string) int {
+	lines := strings.Split(string(data), "\n")
+	if line < 1 || line > len(lines) {
+		return 0
+	}
+
+	return strings.Index(lines[line-1], key) + 1
+}
+
+// valueColumn returns the column of value in line of data or zero if it is not found
+func valueColumn(data []byte, line int, value string) int {
+	lines := strings.Split(string(data), "\n")
+	if value == "" || line < 1 || line > len(lines) {
+		return 0
+	}
+
+	text := lines[line-1]
+
+	// Skip the key of a mapping entry
+	start := 0
+	if i := strings.Index(text, ": "); i >= 0 {
+		start = i + 2
+	}
+
+	i := strings.Index(text[start:], value)
+	if i < 0 {
+		return 0
+	}
+
+	return start + i + 1
+}
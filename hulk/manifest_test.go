@@ -0,0 +1,57 @@
+package hulk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadManifestErrors(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          string
+		expectedError string
+	}{
+		{
+			"Valid",
+			"Topics:\n  - devices/+\nHooks:\n  OnReceive: echo\n",
+			"",
+		},
+
+		{
+			"UnknownKey",
+			"Topics:\n  - devices/+\nTopicz:\n  - devices/#\n",
+			"manifest.yaml:3:1: unknown key Topicz",
+		},
+
+		{
+			"UnknownRuleKey",
+			"Topics:\n  - devices/+\nHooks:\n  OnReceive:\n    - Command: echo\n      Retry: 3\n",
+			"manifest.yaml:6:7: unknown key Retry",
+		},
+
+		{
+			"UnknownActionKey",
+			"Topics:\n  - devices/+\nHooks:\n  OnReceive:\n    - Log:\n        Mesage: hi\n",
+			"manifest.yaml:6:9: unknown key Mesage",
+		},
+
+		{
+			"InvalidValue",
+			"Topics:\n  - devices/+\nHooks:\n  OnReceive:\n    - Command: echo\n      Retries: many\n",
+			"manifest.yaml:6:16: cannot unmarshal !!str `many` into int",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadManifest([]byte(tc.data))
+
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, newManifestError("manifest.yaml", []byte(tc.data), err), tc.expectedError)
+			}
+		})
+	}
+}
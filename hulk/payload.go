@@ -0,0 +1,176 @@
+package hulk
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/OSSystems/pkg/log"
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+// Payload delivery modes
+const (
+	// PayloadStdin writes the payload to the hook standard input
+	PayloadStdin = "stdin"
+	// PayloadFile writes the payload to a temporary file whose path is in HULK_PAYLOAD_FILE
+	PayloadFile = "file"
+)
+
+// PayloadFileVariable holds the path of the payload file in the hook environment
+const PayloadFileVariable = "HULK_PAYLOAD_FILE"
+
+// PayloadVariable is the template variable holding the payload, its fields are available
+// to templates as payload.a.b, array elements as payload.a.N
+const PayloadVariable = "payload"
+
+// isPayloadVariable tells whether name is the payload template variable or one of its fields
+func isPayloadVariable(name string) bool {
+	return name == PayloadVariable || strings.HasPrefix(name, PayloadVariable+".")
+}
+
+// payloadVariables returns the values of the payload variables in names,
+// fields which are missing or null, or of payloads which are not JSON, have no value
+func payloadVariables(payload []byte, names []string) map[string]string {
+	variables := map[string]string{}
+
+	var document interface{}
+	decoded := false
+
+	for _, name := range names {
+		if !isPayloadVariable(name) {
+			continue
+		}
+
+		if name == PayloadVariable {
+			variables[name] = string(payload)
+			continue
+		}
+
+		if !decoded {
+			decoded = true
+			if err := json.Unmarshal(payload, &document); err != nil {
+				document = nil
+			}
+		}
+
+		if value, ok := payloadField(document, strings.Split(name, ".")[1:]); ok {
+			variables[name] = value
+		}
+	}
+
+	return variables
+}
+
+// payloadField returns the field at path of a decoded JSON document as a string,
+// objects and arrays are returned as JSON
+func payloadField(document interface{}, path []string) (string, bool) {
+	value := document
+
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return "", false
+			}
+
+			value = v[index]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+
+	return string(data), true
+}
+
+// createPayloadFile writes payload to a temporary file only readable by the hook user
+func (h *Hook) createPayloadFile(payload []byte) (string, error) {
+	// TempFile creates the file with 0600 permissions
+	file, err := ioutil.TempFile("", "hulk-payload-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create payload file")
+	}
+
+	_, err = file.Write(payload)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = h.chownPayloadFile(file.Name())
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return "", errors.Wrap(err, "failed to write payload file")
+	}
+
+	return file.Name(), nil
+}
+
+// chownPayloadFile gives the ownership of the payload file to the hook user
+func (h *Hook) chownPayloadFile(name string) error {
+	credential, err := h.processOptions().credential()
+	if err != nil || credential == nil {
+		return err
+	}
+
+	return os.Chown(name, int(credential.Uid), int(credential.Gid))
+}
+
+// removePayloadFile removes the payload file if any
+func removePayloadFile(name string, logFields logrus.Fields) {
+	if name == "" {
+		return
+	}
+
+	if err := os.Remove(name); err != nil {
+		log.WithFields(logFields).Warn(errors.Wrap(err, "failed to remove payload file"))
+	}
+}
+
+// writePayload writes payload to the hook standard input and closes it, hooks may exit
+// without reading the whole payload, so the errors of a closed pipe are ignored
+func writePayload(stdin io.WriteCloser, payload []byte) error {
+	_, err := stdin.Write(payload)
+
+	if closeErr := stdin.Close(); err == nil {
+		err = closeErr
+	}
+
+	cause := err
+	if pe, ok := err.(*os.PathError); ok {
+		cause = pe.Err
+	}
+
+	if cause == syscall.EPIPE || cause == os.ErrClosed {
+		return nil
+	}
+
+	return errors.Wrap(err, "failed to write payload to stdin")
+}
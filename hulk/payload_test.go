@@ -0,0 +1,166 @@
+package hulk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayloadVariables(t *testing.T) {
+	testCases := []struct {
+		name              string
+		payload           string
+		names             []string
+		expectedVariables map[string]string
+	}{
+		{
+			"Payload",
+			"raw",
+			[]string{"payload", "DEVICE"},
+			map[string]string{"payload": "raw"},
+		},
+
+		{
+			"Fields",
+			`{"device": {"id": "a1", "on": true}, "items": [1.5, {"b": null}]}`,
+			[]string{"payload.device.id", "payload.device.on", "payload.items.0", "payload.items.1", "payload.items.1.b"},
+			map[string]string{"payload.device.id": "a1", "payload.device.on": "true", "payload.items.0": "1.5", "payload.items.1": `{"b":null}`},
+		},
+
+		{
+			"MissingFields",
+			`{"items": []}`,
+			[]string{"payload.device", "payload.items.0", "payload.items.x"},
+			map[string]string{},
+		},
+
+		{
+			"NotJSON",
+			"raw",
+			[]string{"payload.device"},
+			map[string]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedVariables, payloadVariables([]byte(tc.payload), tc.names))
+		})
+	}
+}
+
+func TestPayloadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "output")
+
+	h := newRuleHook(t, HookRule{
+		Exec:    []string{"sh", "-c", `f=$HULK_PAYLOAD_FILE; (ls -l "$f" | cut -c1-10 && echo "$f" && cat "$f") > "$1"`, "sh", output},
+		Payload: PayloadFile,
+	}, nil)
+
+	variables, err := h.variables([]byte("payload"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.action().run(context.Background(), h, variables, []byte("payload")))
+
+	data, err := ioutil.ReadFile(output)
+	assert.NoError(t, err)
+
+	lines := strings.SplitN(string(data), "\n", 3)
+	assert.Equal(t, "-rw-------", lines[0])
+	assert.Equal(t, "payload", lines[2])
+
+	// The payload file is removed once the hook exits
+	_, err = os.Stat(lines[1])
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPayloadStdinNotRead(t *testing.T) {
+	h := newRuleHook(t, HookRule{Exec: []string{"true"}}, nil)
+
+	// Larger than a pipe buffer, so the write fails once the hook exits
+	payload := []byte(strings.Repeat("a", 1<<20))
+
+	variables, err := h.variables(payload)
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.action().run(context.Background(), h, variables, payload))
+}
+
+// failingWriter is a stdin which fails to be written
+type failingWriter struct{}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk on fire")
+}
+
+func (w *failingWriter) Close() error {
+	return nil
+}
+
+func TestWritePayload(t *testing.T) {
+	testCases := []struct {
+		name          string
+		stdin         func() io.WriteCloser
+		expectedError string
+	}{
+		{
+			"Written",
+			func() io.WriteCloser {
+				r, w, _ := os.Pipe()
+				go ioutil.ReadAll(r)
+				return w
+			},
+			"",
+		},
+
+		{
+			"ReaderClosed",
+			func() io.WriteCloser {
+				r, w, _ := os.Pipe()
+				r.Close()
+				return w
+			},
+			"",
+		},
+
+		{
+			"StdinClosed",
+			func() io.WriteCloser {
+				_, w, _ := os.Pipe()
+				w.Close()
+				return w
+			},
+			"",
+		},
+
+		{
+			"Failed",
+			func() io.WriteCloser { return &failingWriter{} },
+			"failed to write payload to stdin: disk on fire",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := writePayload(tc.stdin(), []byte("payload"))
+
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+//go:build linux
+// +build linux
+
+package hulk
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// supported checks whether the options are supported on this platform
+func (o ProcessOptions) supported() error {
+	return nil
+}
+
+// credential returns the credential to run the process with, the user and group are
+// looked up every time, so they may be created after the manifest is loaded
+func (o ProcessOptions) credential() (*syscall.Credential, error) {
+	if o.User == "" && o.Group == "" {
+		return nil, nil
+	}
+
+	credential := &syscall.Credential{
+		Uid: uint32(syscall.Getuid()),
+		Gid: uint32(syscall.Getgid()),
+	}
+
+	if o.User != "" {
+		u, err := lookupUser(o.User)
+		if err != nil {
+			return nil, err
+		}
+
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+
+		credential.Uid = uint32(uid)
+		credential.Gid = uint32(gid)
+	}
+
+	if o.Group != "" {
+		g, err := lookupGroup(o.Group)
+		if err != nil {
+			return nil, err
+		}
+
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+
+		credential.Gid = uint32(gid)
+	}
+
+	// Drop the supplementary groups inherited from hulkd
+	credential.Groups = []uint32{}
+
+	return credential, nil
+}
+
+// apply sets up the process attributes which are set before cmd starts
+func (o ProcessOptions) apply(cmd *exec.Cmd) error {
+	credential, err := o.credential()
+	if err != nil {
+		return err
+	}
+
+	cmd.Dir = o.WorkingDirectory
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: credential,
+		// Run the hook in its own process group, so it is killed along with its children
+		Setpgid: true,
+	}
+
+	return nil
+}
+
+// kill kills the process group of cmd
+func kill(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// processHelperName is the argv[0] of hulk executed as the process helper, see wrap
+const processHelperName = "hulk-process-helper"
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == processHelperName {
+		runProcessHelper(os.Args[1:])
+	}
+}
+
+// wrap makes cmd run through the process helper, which is hulk itself executed again to apply
+// the umask, resource limits and priority before executing the command, so they are set in the
+// hook process before it starts without requiring a shell, cmd is left untouched if none is set
+//
+// The returned function must be called once cmd was started, it returns the error which
+// prevented the helper from executing the command.
+//
+// The helper runs with the hook credential, so the hook User must be allowed to execute
+// the hulk binary, and a negative 'Nice' or limits above the hard limits of hulkd require
+// the hook to run as root.
+func (o ProcessOptions) wrap(cmd *exec.Cmd) (func() error, error) {
+	mask, err := o.umask()
+	if err != nil {
+		return nil, err
+	}
+
+	if mask < 0 && o.Nice == 0 && o.Limits == (ProcessLimits{}) {
+		return func() error { return nil }, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+
+	args := []string{
+		processHelperName,
+		strconv.Itoa(2 + len(cmd.ExtraFiles)),
+		"-umask", strconv.Itoa(mask),
+		"-nice", strconv.Itoa(o.Nice),
+		"-cpu", strconv.FormatUint(o.Limits.CPU, 10),
+		"-address-space", strconv.FormatUint(o.Limits.AddressSpace, 10),
+		"-open-files", strconv.FormatUint(o.Limits.OpenFiles, 10),
+		"--", cmd.Path,
+	}
+
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+
+	return func() error {
+		// The write end is closed in the helper once the command is executed
+		w.Close()
+		defer r.Close()
+
+		message, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		if len(message) > 0 {
+			return errors.New(string(message))
+		}
+
+		return nil
+	}, nil
+}
+
+// runProcessHelper applies the process options to the helper and executes the command, its
+// arguments are the file descriptor errors are reported to, the options, and the command
+// path followed by its argv, see wrap
+func runProcessHelper(args []string) {
+	// The priority is set for the calling thread, which must be the one executing the command
+	runtime.LockOSThread()
+
+	fd, err := strconv.Atoi(args[0])
+	if err != nil {
+		os.Exit(127)
+	}
+
+	fail := func(err error) {
+		fmt.Fprint(os.NewFile(uintptr(fd), "errors"), err)
+		os.Exit(127)
+	}
+
+	syscall.CloseOnExec(fd)
+
+	flags := flag.NewFlagSet(processHelperName, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+
+	mask := flags.Int("umask", -1, "")
+	nice := flags.Int("nice", 0, "")
+	cpu := flags.Uint64("cpu", 0, "")
+	addressSpace := flags.Uint64("address-space", 0, "")
+	openFiles := flags.Uint64("open-files", 0, "")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		fail(errors.Wrap(err, "invalid process helper arguments"))
+	}
+
+	if flags.NArg() < 2 {
+		fail(errors.New("invalid process helper arguments: missing command"))
+	}
+
+	if *mask >= 0 {
+		syscall.Umask(*mask)
+	}
+
+	if *nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *nice); err != nil {
+			fail(errors.Wrap(err, "failed to set Nice"))
+		}
+	}
+
+	// The limits are set as both soft and hard limits
+	limits := []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"CPU", syscall.RLIMIT_CPU, *cpu},
+		{"AddressSpace", syscall.RLIMIT_AS, *addressSpace},
+		{"OpenFiles", syscall.RLIMIT_NOFILE, *openFiles},
+	}
+
+	for _, limit := range limits {
+		if limit.value == 0 {
+			continue
+		}
+
+		if err := syscall.Setrlimit(limit.resource, &syscall.Rlimit{Cur: limit.value, Max: limit.value}); err != nil {
+			fail(errors.Wrapf(err, "failed to set %s limit", limit.name))
+		}
+	}
+
+	path := flags.Arg(0)
+
+	err = syscall.Exec(path, flags.Args()[1:], os.Environ())
+
+	fail(&os.PathError{Op: "exec", Path: path, Err: err})
+}
@@ -0,0 +1,50 @@
+//go:build !linux
+// +build !linux
+
+package hulk
+
+import (
+	"os/exec"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// supported checks whether the options are supported on this platform
+func (o ProcessOptions) supported() error {
+	if o.User != "" || o.Group != "" {
+		return errors.New("User and Group are not supported on this platform")
+	}
+
+	if o.Umask != "" || o.Nice != 0 || o.Limits != (ProcessLimits{}) {
+		return errors.New("Umask, Nice and Limits are not supported on this platform")
+	}
+
+	return nil
+}
+
+// credential is only supported on Linux
+func (o ProcessOptions) credential() (*syscall.Credential, error) {
+	return nil, o.supported()
+}
+
+// apply sets up the process attributes which are set before cmd starts
+func (o ProcessOptions) apply(cmd *exec.Cmd) error {
+	cmd.Dir = o.WorkingDirectory
+
+	return nil
+}
+
+// kill kills the process of cmd
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// wrap is only supported on Linux
+func (o ProcessOptions) wrap(cmd *exec.Cmd) (func() error, error) {
+	if err := o.supported(); err != nil {
+		return nil, err
+	}
+
+	return func() error { return nil }, nil
+}
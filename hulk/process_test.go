@@ -0,0 +1,227 @@
+//go:build linux
+// +build linux
+
+package hulk
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessOptionsWrap(t *testing.T) {
+	testCases := []struct {
+		name           string
+		options        ProcessOptions
+		expectedOutput string
+	}{
+		{
+			"Unchanged",
+			ProcessOptions{},
+			"",
+		},
+
+		{
+			"Umask",
+			ProcessOptions{Umask: "027"},
+			"0027",
+		},
+
+		{
+			"OpenFiles",
+			ProcessOptions{Limits: ProcessLimits{OpenFiles: 64}},
+			"64",
+		},
+
+		{
+			"CPU",
+			ProcessOptions{Limits: ProcessLimits{CPU: 10}},
+			"10",
+		},
+
+		{
+			"AddressSpace",
+			ProcessOptions{Limits: ProcessLimits{AddressSpace: 1 << 30}},
+			"1048576",
+		},
+
+		{
+			"Nice",
+			ProcessOptions{Nice: 19},
+			"nice=19",
+		},
+	}
+
+	// The niceness is the 19th field of /proc/self/stat
+	script := `umask; ulimit -n; ulimit -t; ulimit -v; read -r stat < /proc/self/stat; set -- $stat; echo "nice=${19}"`
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			unchanged, err := exec.Command("sh", "-c", script).Output()
+			assert.NoError(t, err)
+
+			cmd := exec.Command("sh", "-c", script)
+			path := cmd.Path
+
+			helperError, err := tc.options.wrap(cmd)
+			assert.NoError(t, err)
+
+			output, err := cmd.Output()
+			assert.NoError(t, err)
+			assert.NoError(t, helperError())
+
+			if tc.expectedOutput == "" {
+				assert.Equal(t, path, cmd.Path)
+				assert.Equal(t, []string{"sh", "-c", script}, cmd.Args)
+				assert.Equal(t, string(unchanged), string(output))
+			} else {
+				assert.Equal(t, processHelperName, cmd.Args[0])
+				assert.Contains(t, strings.Fields(string(output)), tc.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestProcessOptionsWrapInvalidUmask(t *testing.T) {
+	_, err := ProcessOptions{Umask: "999"}.wrap(exec.Command("true"))
+
+	assert.EqualError(t, err, "invalid Umask: 999")
+}
+
+func TestProcessOptionsWrapHelperError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	// The file is not executable, so the helper fails to execute it
+	path := filepath.Join(dir, "hook")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0644))
+
+	cmd := exec.Command(path)
+
+	helperError, err := ProcessOptions{Umask: "022"}.wrap(cmd)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cmd.Start())
+	assert.EqualError(t, helperError(), "exec "+path+": permission denied")
+	assert.Error(t, cmd.Wait())
+}
+
+func TestProcessOptionsValidate(t *testing.T) {
+	// Users and groups are looked up when the hook runs, since they may be created later
+	options := ProcessOptions{User: "hulk-missing-user", Group: "hulk-missing-group"}
+	assert.NoError(t, options.validate())
+
+	_, err := options.credential()
+	assert.Error(t, err)
+}
+
+func TestExecProcessOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "output")
+
+	notExecutable := filepath.Join(dir, "hook")
+	assert.NoError(t, ioutil.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0644))
+
+	testCases := []struct {
+		name           string
+		rule           HookRule
+		expectedOutput string
+		expectedError  string
+	}{
+		{
+			"Applied",
+			HookRule{
+				Exec:           []string{"sh", "-c", `umask > "$1"`, "sh", output},
+				ProcessOptions: ProcessOptions{Umask: "027"},
+			},
+			"0027\n",
+			"",
+		},
+
+		{
+			"HelperError",
+			HookRule{
+				Exec:           []string{notExecutable},
+				ProcessOptions: ProcessOptions{Umask: "027"},
+			},
+			"",
+			"exec " + notExecutable + ": permission denied",
+		},
+
+		{
+			"UnknownUser",
+			HookRule{
+				Exec:           []string{"true"},
+				ProcessOptions: ProcessOptions{User: "hulk-missing-user"},
+			},
+			"",
+			"user: unknown user hulk-missing-user",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Remove(output)
+
+			h := newRuleHook(t, tc.rule, nil)
+
+			variables, err := h.variables(nil)
+			assert.NoError(t, err)
+
+			err = h.action().run(context.Background(), h, variables, nil)
+
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+
+			data, err := ioutil.ReadFile(output)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedOutput, string(data))
+		})
+	}
+}
+
+func TestFileActionWriteAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	current, err := user.Current()
+	assert.NoError(t, err)
+
+	path := filepath.Join(dir, "file.bin")
+	options := ProcessOptions{User: current.Username}
+
+	a := &fileAction{&FileAction{Path: path, Append: true}}
+
+	assert.NoError(t, a.writeAs(context.Background(), options, path, 0640, []byte("a")))
+	assert.NoError(t, a.writeAs(context.Background(), options, path, 0600, []byte("b")))
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "ab", string(data))
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	err = a.writeAs(context.Background(), options, filepath.Join(dir, "missing", "file.bin"), 0600, []byte("a"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+package hulk
+
+import (
+	"os/user"
+	"strconv"
+
+	"github.com/OSSystems/hulk/api/types"
+	"github.com/pkg/errors"
+)
+
+// ProcessOptions represents the process attributes of hook commands
+type ProcessOptions struct {
+	User             string        `yaml:"User,omitempty"`
+	Group            string        `yaml:"Group,omitempty"`
+	WorkingDirectory string        `yaml:"WorkingDirectory,omitempty"`
+	Umask            string        `yaml:"Umask,omitempty"`
+	Nice             int           `yaml:"Nice,omitempty"`
+	Limits           ProcessLimits `yaml:"Limits,omitempty"`
+}
+
+// ProcessLimits represents the resource limits of hook commands, zero means unchanged
+type ProcessLimits struct {
+	// CPU is the CPU time limit in seconds
+	CPU uint64 `yaml:"CPU,omitempty"`
+	// AddressSpace is the virtual memory limit in bytes
+	AddressSpace uint64 `yaml:"AddressSpace,omitempty"`
+	// OpenFiles is the limit of open file descriptors
+	OpenFiles uint64 `yaml:"OpenFiles,omitempty"`
+}
+
+// toAPI converts the options to API type, returns nil if there is no option set
+func (o ProcessOptions) toAPI() *types.ProcessOptions {
+	if o == (ProcessOptions{}) {
+		return nil
+	}
+
+	options := &types.ProcessOptions{
+		User:             o.User,
+		Group:            o.Group,
+		WorkingDirectory: o.WorkingDirectory,
+		Umask:            o.Umask,
+		Nice:             o.Nice,
+	}
+
+	options.Limits.CPU = o.Limits.CPU
+	options.Limits.AddressSpace = o.Limits.AddressSpace
+	options.Limits.OpenFiles = o.Limits.OpenFiles
+
+	return options
+}
+
+// merge returns the options overridden by the non-zero fields of other
+func (o ProcessOptions) merge(other ProcessOptions) ProcessOptions {
+	if other.User != "" {
+		o.User = other.User
+	}
+
+	if other.Group != "" {
+		o.Group = other.Group
+	}
+
+	if other.WorkingDirectory != "" {
+		o.WorkingDirectory = other.WorkingDirectory
+	}
+
+	if other.Umask != "" {
+		o.Umask = other.Umask
+	}
+
+	if other.Nice != 0 {
+		o.Nice = other.Nice
+	}
+
+	if other.Limits.CPU != 0 {
+		o.Limits.CPU = other.Limits.CPU
+	}
+
+	if other.Limits.AddressSpace != 0 {
+		o.Limits.AddressSpace = other.Limits.AddressSpace
+	}
+
+	if other.Limits.OpenFiles != 0 {
+		o.Limits.OpenFiles = other.Limits.OpenFiles
+	}
+
+	return o
+}
+
+// validate checks whether the options are valid, the User and Group are only
+// looked up when the hook runs
+func (o ProcessOptions) validate() error {
+	if err := o.supported(); err != nil {
+		return err
+	}
+
+	if _, err := o.umask(); err != nil {
+		return err
+	}
+
+	if o.Nice < -20 || o.Nice > 19 {
+		return errors.Errorf("invalid Nice: %d", o.Nice)
+	}
+
+	return nil
+}
+
+// umask returns the parsed octal umask or -1 if it is not set
+func (o ProcessOptions) umask() (int, error) {
+	if o.Umask == "" {
+		return -1, nil
+	}
+
+	mask, err := strconv.ParseUint(o.Umask, 8, 32)
+	if err != nil || mask > 0777 {
+		return -1, errors.Errorf("invalid Umask: %s", o.Umask)
+	}
+
+	return int(mask), nil
+}
+
+// lookupUser looks up a user by name or uid
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+// lookupGroup looks up a group by name or gid
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupGroupId(name)
+	}
+
+	return user.LookupGroup(name)
+}
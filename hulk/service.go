@@ -1,14 +1,21 @@
 package hulk
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/OSSystems/pkg/log"
+	"github.com/OSSystems/hulk/api/types"
+	"github.com/OSSystems/hulk/filter"
+	"github.com/OSSystems/hulk/pkg/secret"
 	"github.com/OSSystems/hulk/template"
+	"github.com/OSSystems/pkg/log"
 	"github.com/Sirupsen/logrus"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
@@ -18,39 +25,189 @@ import (
 type Service struct {
 	hulk        *Hulk
 	name        string
+	file        string
 	manifest    Manifest
+	templates   []*template.Template
 	topics      []string
+	patterns    []*topicPattern
 	enabled     bool
+	status      *types.ServiceStatus
+	failure     *types.ServiceStatus
 	environment map[string]string
+	inline      map[string]*template.Template
+	filter      *filter.Filter
+	rules       map[HookName][]*hookRule
+	masker      *secret.Masker
+	history     []*execution
+	historyLock sync.Mutex
+
+	// expandedEnvironment is the environment the topics were last expanded with
+	expandedEnvironment map[string]string
 }
 
 // NewService creates a new Service from manifest file
 func NewService(hulk *Hulk, filename string) (*Service, error) {
 	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, &ManifestError{File: filename, Err: err}
+	}
 
 	manifest, err := LoadManifest(data)
 	if err != nil {
-		return nil, err
+		return nil, newManifestError(filename, data, err)
 	}
 
-	basename := path.Base(filename)
+	service, err := newService(hulk, serviceName(filename), manifest)
+	if err != nil {
+		return nil, &ManifestError{File: filename, Err: err}
+	}
+
+	service.file = filename
+
+	return service, nil
+}
+
+// newBrokenService creates a disabled Service for a manifest file which could not be loaded
+func newBrokenService(hulk *Hulk, filename string, err error) *Service {
+	status := &types.ServiceStatus{
+		Reason:  types.StatusReasonManifestError,
+		Message: err.Error(),
+		File:    filename,
+	}
+
+	if me, ok := err.(*ManifestError); ok {
+		status.Line = me.Line
+		status.Column = me.Column
+	}
 
 	return &Service{
 		hulk:        hulk,
-		name:        strings.TrimSuffix(basename, filepath.Ext(basename)),
+		name:        serviceName(filename),
+		file:        filename,
+		environment: make(map[string]string),
+		rules:       map[HookName][]*hookRule{},
+		masker:      hulk.masker,
+		failure:     status,
+	}
+}
+
+// serviceName returns the name of the service of manifest file
+func serviceName(filename string) string {
+	basename := path.Base(filename)
+
+	return strings.TrimSuffix(basename, filepath.Ext(basename))
+}
+
+// newService creates a new Service named name from manifest
+func newService(hulk *Hulk, name string, manifest Manifest) (*Service, error) {
+	if manifest.Hooks.OnReceiveMode == "" {
+		manifest.Hooks.OnReceiveMode = HookModeFirst
+	}
+
+	service := &Service{
+		hulk:        hulk,
+		name:        name,
 		manifest:    manifest,
 		environment: make(map[string]string),
 		enabled:     false,
-	}, nil
+		masker:      hulk.masker.With(manifest.SecretVariables...),
+	}
+
+	if manifest.Filter != "" {
+		var err error
+		if service.filter, err = filter.Compile(manifest.Filter); err != nil {
+			return nil, errors.Wrap(err, "invalid filter")
+		}
+	}
+
+	service.inline = map[string]*template.Template{}
+
+	for key, value := range manifest.Environment {
+		tpl, err := template.Compile(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid Environment %s", key)
+		}
+
+		service.inline[key] = tpl
+	}
+
+	for _, topic := range manifest.Topics {
+		tpl, err := template.Compile(topic)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid topic %s", topic)
+		}
+
+		service.templates = append(service.templates, tpl)
+	}
+
+	if manifest.Hooks.OnReceiveMode != HookModeFirst && manifest.Hooks.OnReceiveMode != HookModeAll {
+		return nil, fmt.Errorf("invalid OnReceiveMode: %s", manifest.Hooks.OnReceiveMode)
+	}
+
+	service.rules = map[HookName][]*hookRule{}
+
+	for i, r := range manifest.Hooks.OnReceive {
+		rule, err := newHookRule(i, r, manifest.ProcessOptions)
+
+		if _, ok := hulk.registry[r.Handler]; err == nil && r.Handler != "" && !ok {
+			err = errors.Errorf("unknown Handler: %s", r.Handler)
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid OnReceive rule %s", hookRuleName(i, r))
+		}
+
+		service.rules[OnReceiveHook] = append(service.rules[OnReceiveHook], rule)
+	}
+
+	return service, nil
 }
 
-// loadEnvironment loads environment variables from 'EnvironmentFiles' specified in the service manifest
+// removedEnvironmentFile returns the first environment file of the service which was removed
+// while hulk was running or an empty string if there is none
+func (s *Service) removedEnvironmentFile() string {
+	for _, file := range s.manifest.EnvironmentFiles {
+		if s.hulk.removedFiles[file] {
+			return file
+		}
+	}
+
+	return ""
+}
+
+// loadEnvironment loads the service environment, see environment.go for the precedence order
 func (s *Service) loadEnvironment() {
-	s.environment = map[string]string{}
+	s.environment = hostEnvironment(s.manifest.PassEnvironment)
+
+	for key, value := range s.hulk.environment {
+		s.environment[key] = value
+	}
 
 	for _, file := range s.manifest.EnvironmentFiles {
 		s.loadEnvironmentFile(file)
 	}
+
+	// Inline variables are expanded with the variables of the previous sources only,
+	// a variable which fails to expand is left out
+	inline := map[string]string{}
+
+	for key, tpl := range s.inline {
+		expanded, err := tpl.Expand(s.environment)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"service": s.name,
+				"key":     key,
+				"reason":  s.mask(variableError(err).Error()),
+			}).Warn("failed to expand inline environment variable")
+			continue
+		}
+
+		inline[key] = strings.Join(expanded, " ")
+	}
+
+	for key, value := range inline {
+		s.environment[key] = value
+	}
 }
 
 // loadEnvironmentFile loads specified environment file
@@ -81,55 +238,243 @@ func (s *Service) loadEnvironmentFile(file string) {
 		log.WithFields(logrus.Fields{
 			"service": s.name,
 			"key":     key,
-			"value":   value,
+			"value":   s.masker.Value(key, value),
 			"file":    file,
 		}).Debug("environment variable loaded")
 	}
 }
 
-// expandTopics expands topics from service manifest
+// expandTopics expands topics from service manifest, the subscriptions are left untouched
 func (s *Service) expandTopics() {
-	for _, topic := range s.topics {
+	s.expandedEnvironment = s.environment
+
+	s.topics = s.topics[:0]
+	s.patterns = s.patterns[:0]
+
+	for _, tpl := range s.templates {
+		if !s.expandTopic(tpl) {
+			break
+		}
+	}
+
+	for _, rules := range s.rules {
+		for _, rule := range rules {
+			s.expandRule(rule)
+		}
+	}
+}
+
+// expandTopic expands a topic template of the service manifest, it returns false
+// if the service was disabled because the topic is invalid
+func (s *Service) expandTopic(tpl *template.Template) bool {
+	topic := tpl.String()
+
+	expanded, err := tpl.Expand(s.environment)
+	if err != nil {
+		if ve, ok := err.(*template.VariableExpandError); ok {
+			logEntry := log.WithFields(logrus.Fields{
+				"service":  s.name,
+				"topic":    topic,
+				"variable": ve.Name,
+				"position": ve.Position,
+			})
+
+			if ve.IsOptional {
+				logEntry.Warn("no value for optional variable")
+
+				// If value of variable is optional them ignore ONLY the current topic
+				return true
+			}
+
+			logEntry.Data["reason"] = err
+			logEntry.Error("service disabled")
+
+			// If the value of variable is required them disable service
+			// and ignore ALL topics from manifest
+			s.disable(&types.ServiceStatus{
+				Reason:   types.StatusReasonMissingVariable,
+				Message:  fmt.Sprintf("%s: %s", err, ve.Name),
+				Variable: ve.Name,
+				Topic:    topic,
+			})
+
+			return false
+		}
+
+		// Errors like array size mismatches and too many expansions
+		s.disableInvalidTopic(topic, err)
+
+		return false
+	}
+
+	for _, t := range expanded {
+		pattern := parseTopicPattern(t)
+
+		if err := pattern.checkCaptures(s.captureEnvironments()...); err != nil {
+			s.disableInvalidTopic(topic, err)
+			return false
+		}
+
+		s.topics = append(s.topics, pattern.filter)
+		s.patterns = append(s.patterns, pattern)
+	}
+
+	return true
+}
+
+// captureEnvironments returns the environments the captures of the service topics
+// must not collide with: the service environment and the inline environment of the rules
+func (s *Service) captureEnvironments() []map[string]string {
+	envs := []map[string]string{s.environment}
+
+	for _, rules := range s.rules {
+		for _, rule := range rules {
+			envs = append(envs, rule.Environment)
+		}
+	}
+
+	return envs
+}
+
+// disableInvalidTopic disables the service because topic is invalid
+func (s *Service) disableInvalidTopic(topic string, err error) {
+	log.WithFields(logrus.Fields{
+		"service": s.name,
+		"topic":   s.mask(topic),
+		"reason":  s.mask(err.Error()),
+	}).Error("service disabled")
+
+	s.disable(&types.ServiceStatus{
+		Reason:  types.StatusReasonInvalidTopic,
+		Message: s.mask(fmt.Sprintf("invalid topic %s: %s", topic, err)),
+		Topic:   topic,
+	})
+}
+
+// disable disables the service with status and clears its topics,
+// the first reason is kept if the service was already disabled
+func (s *Service) disable(status *types.ServiceStatus) {
+	if s.enabled {
+		s.status = status
+	}
+
+	s.enabled = false
+	s.topics = s.topics[:0]
+	s.patterns = s.patterns[:0]
+}
+
+// expandRule expands the topic of a hook rule
+func (s *Service) expandRule(rule *hookRule) {
+	rule.patterns = rule.patterns[:0]
+
+	if rule.Topic == "" {
+		return
+	}
+
+	expanded, err := rule.topic.Expand(s.environment)
+	if err != nil {
 		log.WithFields(logrus.Fields{
 			"service": s.name,
-			"topic":   topic,
-		}).Info("unsubscribe from topic")
-		s.hulk.unsubscribe(topic, s)
+			"rule":    rule.name(),
+			"topic":   rule.Topic,
+			"reason":  s.mask(err.Error()),
+		}).Warn("hook rule disabled")
+		return
 	}
 
-	s.topics = s.topics[:0]
+	for _, t := range expanded {
+		pattern := parseTopicPattern(t)
 
-	for _, topic := range s.manifest.Topics {
-		expanded, err := template.Expand(topic, s.environment)
-		if err != nil {
-			if ve, ok := err.(*template.VariableExpandError); ok {
-				logEntry := log.WithFields(logrus.Fields{
-					"service":  s.name,
-					"topic":    topic,
-					"variable": ve.Name,
-				})
-
-				if ve.IsOptional {
-					logEntry.Warn("no value for optional variable")
-
-					// If value of variable is optional them ignore ONLY the current topic
-					continue
-				} else {
-					logEntry.Data["reason"] = err
-					logEntry.Error("service disabled")
-
-					// If the value of variable is required them disable service,
-					// clear the topic list and ignore ALL topics from manifest
-					s.enabled = false
-					s.topics = s.topics[:0]
-
-					break
-				}
+		if err := pattern.checkCaptures(s.environment, rule.Environment); err != nil {
+			log.WithFields(logrus.Fields{
+				"service": s.name,
+				"rule":    rule.name(),
+				"topic":   rule.Topic,
+				"reason":  s.mask(err.Error()),
+			}).Warn("hook rule disabled")
+
+			rule.patterns = rule.patterns[:0]
+			return
+		}
+
+		rule.patterns = append(rule.patterns, pattern)
+	}
+}
+
+// variables returns the sorted names of the environment variables referenced by the
+// service topics and hook rule topics
+func (s *Service) variables() []string {
+	templates := append([]*template.Template{}, s.templates...)
+
+	for _, rules := range s.rules {
+		for _, rule := range rules {
+			if rule.topic != nil {
+				templates = append(templates, rule.topic)
 			}
 		}
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+
+	for _, tpl := range templates {
+		for _, variable := range tpl.Variables() {
+			if !seen[variable.Name] {
+				seen[variable.Name] = true
+				names = append(names, variable.Name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
 
-		s.topics = append(s.topics, expanded...)
+// captures returns the named segments captured from topic
+func (s *Service) captures(topic string) map[string]string {
+	captures := map[string]string{}
+
+	for _, pattern := range s.patterns {
+		values, ok := pattern.match(topic)
+		if !ok {
+			continue
+		}
+
+		for name, value := range values {
+			captures[name] = value
+		}
 	}
+
+	return captures
+}
+
+// topicVariablesChanged tells whether the variables referenced by the topics changed
+// since the topics were last expanded
+func (s *Service) topicVariablesChanged() bool {
+	if s.expandedEnvironment == nil {
+		return true
+	}
+
+	for _, name := range s.variables() {
+		old, wasSet := s.expandedEnvironment[name]
+		value, isSet := s.environment[name]
+
+		if wasSet != isSet || old != value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscribedTopics returns the topics the service is subscribed to
+func (s *Service) subscribedTopics() []string {
+	if !s.enabled {
+		return nil
+	}
+
+	return append([]string{}, s.topics...)
 }
 
 // subscribe subscribes to topics
@@ -140,42 +485,272 @@ func (s *Service) subscribe() {
 	}
 
 	for _, topic := range s.topics {
-		log.WithFields(logrus.Fields{
-			"service": s.name,
-			"topic":   topic,
-		}).Info("subscribe to topic")
+		s.subscribeTopic(topic)
+	}
+}
 
-		err := s.hulk.subscribe(topic, s)
-		if err != nil {
-			log.Warn(err)
+// unsubscribe unsubscribes from topics
+func (s *Service) unsubscribe() {
+	for _, topic := range s.topics {
+		s.unsubscribeTopic(topic)
+	}
+}
+
+// updateSubscriptions subscribes to the topics which were not in subscribed, the topics
+// the service was subscribed to before, and unsubscribes from the ones which were removed
+func (s *Service) updateSubscriptions(subscribed []string) {
+	// Count the topics since the same topic may be subscribed more than once
+	count := map[string]int{}
+
+	for _, topic := range subscribed {
+		count[topic]--
+	}
+
+	for _, topic := range s.subscribedTopics() {
+		count[topic]++
+	}
+
+	for _, topic := range append(subscribed, s.subscribedTopics()...) {
+		for ; count[topic] < 0; count[topic]++ {
+			s.unsubscribeTopic(topic)
+		}
+
+		for ; count[topic] > 0; count[topic]-- {
+			s.subscribeTopic(topic)
 		}
 	}
 }
 
-// messageHandler handles received messages on topic
-func (s *Service) messageHandler(topic string, payload []byte) {
-	err := s.executeHook(OnReceiveHook, topic, payload)
+// subscribeTopic subscribes to topic
+func (s *Service) subscribeTopic(topic string) {
+	log.WithFields(logrus.Fields{
+		"service": s.name,
+		"topic":   s.mask(topic),
+	}).Info("subscribe to topic")
+
+	err := s.hulk.subscribe(topic, s)
 	if err != nil {
 		log.Warn(err)
 	}
 }
 
-// executeHook executes hook name
-func (s *Service) executeHook(name HookName, topic string, payload []byte) error {
-	hook := NewHook(s, name, topic)
+// unsubscribeTopic unsubscribes from topic
+func (s *Service) unsubscribeTopic(topic string) {
+	log.WithFields(logrus.Fields{
+		"service": s.name,
+		"topic":   s.mask(topic),
+	}).Info("unsubscribe from topic")
+
+	s.hulk.unsubscribe(topic, s)
+}
+
+// messageHandler handles received messages on topic
+func (s *Service) messageHandler(topic string, payload []byte) {
+	now := time.Now()
+	filterResult := ""
+
+	if s.filter != nil {
+		filterResult = filterRejected
+		if s.filter.Match(topic, payload) {
+			filterResult = filterMatched
+		}
+
+		log.WithFields(logrus.Fields{
+			"service": s.name,
+			"topic":   s.mask(topic),
+			"filter":  s.mask(s.filter.String()),
+			"result":  filterResult,
+		}).Debug("filter evaluated")
+
+		if filterResult == filterRejected {
+			s.addExecution(&execution{time: now, topic: topic, hook: OnReceiveHook, filter: filterResult})
+			return
+		}
+	}
+
+	matches := s.matchRules(OnReceiveHook, topic, payload)
+	if len(matches) == 0 {
+		log.WithFields(logrus.Fields{
+			"service": s.name,
+			"topic":   s.mask(topic),
+			"hook":    HookNameToString(OnReceiveHook),
+		}).Debug("no hook rule matched")
+
+		s.addExecution(&execution{time: now, topic: topic, hook: OnReceiveHook, filter: filterResult})
+		return
+	}
+
+	for _, m := range matches {
+		e := &execution{
+			time:   now,
+			topic:  topic,
+			hook:   OnReceiveHook,
+			rule:   m.rule.name(),
+			filter: filterResult,
+		}
+
+		s.addExecution(e)
+		s.executeHook(OnReceiveHook, m.rule, topic, m.captures, payload, e)
+	}
+}
+
+// ruleMatch represents a hook rule matching a received message
+type ruleMatch struct {
+	rule     *hookRule
+	captures map[string]string
+}
+
+// matchRules returns the rules of hook name matching topic and payload
+func (s *Service) matchRules(name HookName, topic string, payload []byte) []*ruleMatch {
+	matches := []*ruleMatch{}
+
+	for _, rule := range s.rules[name] {
+		ruleCaptures, ok := rule.match(topic, payload)
+		if !ok {
+			continue
+		}
+
+		// Rule captures take precedence over the service topic captures
+		captures := s.captures(topic)
+		for key, value := range ruleCaptures {
+			captures[key] = value
+		}
+
+		matches = append(matches, &ruleMatch{rule: rule, captures: captures})
+
+		if s.hookMode(name) == HookModeFirst {
+			break
+		}
+	}
+
+	return matches
+}
+
+// hookMode returns the rule matching mode of hook name
+func (s *Service) hookMode(name HookName) string {
+	switch name {
+	case OnReceiveHook:
+		return s.manifest.Hooks.OnReceiveMode
+	}
+
+	return HookModeFirst
+}
+
+// executeHook executes hook rule in background and records the result in execution e
+func (s *Service) executeHook(name HookName, rule *hookRule, topic string, captures map[string]string, payload []byte, e *execution) {
+	hook := NewHook(s, name, rule, topic, captures)
 
 	if hook == nil {
 		log.WithFields(logrus.Fields{
 			"service": s.name,
 			"hook":    HookNameToString(name),
+			"rule":    rule.name(),
 		}).Debug("cannot find hook or it is empty")
-		return nil
+		return
 	}
 
-	err := hook.execute(payload)
-	if err != nil {
-		return errors.Wrapf(err, "failed to execute %s", HookNameToString(name))
+	done := func(err error) {
+		if err != nil {
+			err = errors.Wrapf(err, "failed to execute %s", HookNameToString(name))
+			log.Warn(hook.mask(err.Error()))
+		}
+
+		s.finishExecution(e, err)
+	}
+
+	s.startExecution(e)
+
+	if err := hook.execute(payload, done); err != nil {
+		done(err)
+	}
+}
+
+// hookRuleToAPI converts a hook rule to API type with secret values masked
+func (s *Service) hookRuleToAPI(rule HookRule) *types.HookRule {
+	r := &types.HookRule{
+		Name:    rule.Name,
+		Topic:   s.mask(rule.Topic),
+		Filter:  s.mask(rule.Filter),
+		Command: s.mask(rule.Command),
+		Handler: rule.Handler,
+		Retries: rule.Retries,
+		Payload: rule.Payload,
+		Process: rule.ProcessOptions.toAPI(),
+	}
+
+	if len(rule.Exec) > 0 {
+		r.Exec = s.maskStrings(rule.Exec)
+	}
+
+	if len(rule.Environment) > 0 {
+		r.Environment = s.maskEnvironment(rule.Environment)
+	}
+
+	if rule.Timeout > 0 {
+		r.Timeout = rule.Timeout.String()
+	}
+
+	if rule.RetryDelay > 0 {
+		r.RetryDelay = rule.RetryDelay.String()
+	}
+
+	if rule.HTTP != nil {
+		r.HTTP = &types.HTTPAction{
+			Method: rule.HTTP.Method,
+			URL:    s.mask(rule.HTTP.URL),
+			Body:   s.mask(rule.HTTP.Body),
+		}
+
+		if len(rule.HTTP.Headers) > 0 {
+			r.HTTP.Headers = s.maskEnvironment(rule.HTTP.Headers)
+		}
+	}
+
+	if rule.File != nil {
+		r.File = &types.FileAction{
+			Path:   s.mask(rule.File.Path),
+			Append: rule.File.Append,
+			Mode:   rule.File.Mode,
+		}
+	}
+
+	if rule.Publish != nil {
+		r.Publish = &types.PublishAction{
+			Topic:   s.mask(rule.Publish.Topic),
+			Payload: s.mask(rule.Publish.Payload),
+			QoS:     rule.Publish.QoS,
+			Retain:  rule.Publish.Retain,
+		}
+	}
+
+	if rule.Log != nil {
+		r.Log = &types.LogAction{
+			Level:   rule.Log.Level,
+			Message: s.mask(rule.Log.Message),
+		}
+	}
+
+	return r
+}
+
+// mask masks the secret values of the service environment found in str
+func (s *Service) mask(str string) string {
+	return s.masker.String(str, s.environment)
+}
+
+// maskStrings masks the secret values of the service environment found in each element of list
+func (s *Service) maskStrings(list []string) []string {
+	return s.masker.Strings(list, s.environment)
+}
+
+// maskEnvironment masks the secret variables of env and the secret values
+// of the service environment found in the other variables
+func (s *Service) maskEnvironment(env map[string]string) map[string]string {
+	masked := s.masker.Environment(env)
+
+	for key, value := range masked {
+		masked[key] = s.mask(value)
 	}
 
-	return nil
+	return masked
 }
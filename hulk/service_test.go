@@ -0,0 +1,243 @@
+package hulk
+
+import (
+	"testing"
+
+	"github.com/OSSystems/hulk/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandTopics(t *testing.T) {
+	testCases := []struct {
+		name            string
+		topics          []string
+		environment     map[string]string
+		expectedTopics  []string
+		expectedEnabled bool
+		expectedStatus  *types.ServiceStatus
+	}{
+		{
+			"Expanded",
+			[]string{"devices/{DEVICE}/+", "groups/{GROUPS[,]}/{+group}"},
+			map[string]string{"DEVICE": "1", "GROUPS": "a,b"},
+			[]string{"devices/1/+", "groups/a/+", "groups/b/+"},
+			true,
+			nil,
+		},
+
+		{
+			"MissingOptional",
+			[]string{"devices/{DEVICE}?", "groups/all"},
+			map[string]string{},
+			[]string{"groups/all"},
+			true,
+			nil,
+		},
+
+		{
+			"MissingRequired",
+			[]string{"groups/all", "devices/{DEVICE}"},
+			map[string]string{},
+			[]string{},
+			false,
+			&types.ServiceStatus{
+				Reason:   types.StatusReasonMissingVariable,
+				Message:  "No value for required variable: DEVICE",
+				Variable: "DEVICE",
+				Topic:    "devices/{DEVICE}",
+			},
+		},
+
+		{
+			"ArraySizeDiffers",
+			[]string{"devices/{DEVICES[,]}/{MODELS[,]}"},
+			map[string]string{"DEVICES": "1,2", "MODELS": "a"},
+			[]string{},
+			false,
+			&types.ServiceStatus{
+				Reason:  types.StatusReasonInvalidTopic,
+				Message: "invalid topic devices/{DEVICES[,]}/{MODELS[,]}: Array size differs: MODELS (1 should be 2)",
+				Topic:   "devices/{DEVICES[,]}/{MODELS[,]}",
+			},
+		},
+
+		{
+			"CaptureCollision",
+			[]string{"devices/{+DEVICE}"},
+			map[string]string{"DEVICE": "1"},
+			[]string{},
+			false,
+			&types.ServiceStatus{
+				Reason:  types.StatusReasonInvalidTopic,
+				Message: "invalid topic devices/{+DEVICE}: capture DEVICE collides with an environment variable",
+				Topic:   "devices/{+DEVICE}",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := NewHulk(newFakeClient(), "")
+			assert.NoError(t, err)
+
+			s, err := newService(h, "devices", Manifest{Topics: tc.topics})
+			assert.NoError(t, err)
+
+			s.enabled = true
+			s.environment = tc.environment
+			s.expandTopics()
+
+			assert.Equal(t, tc.expectedTopics, append([]string{}, s.topics...))
+			assert.Equal(t, tc.expectedEnabled, s.enabled)
+			assert.Equal(t, tc.expectedStatus, s.status)
+		})
+	}
+}
+
+func TestUpdateSubscriptions(t *testing.T) {
+	testCases := []struct {
+		name             string
+		subscribed       []string
+		topics           []string
+		enabled          bool
+		expectedCalls    []string
+		expectedHandlers map[string]int
+	}{
+		{
+			"Unchanged",
+			[]string{"a", "b"},
+			[]string{"a", "b"},
+			true,
+			nil,
+			map[string]int{"a": 1, "b": 1},
+		},
+
+		{
+			"Reordered",
+			[]string{"a", "b"},
+			[]string{"b", "a"},
+			true,
+			nil,
+			map[string]int{"a": 1, "b": 1},
+		},
+
+		{
+			"Added",
+			[]string{"a"},
+			[]string{"a", "b"},
+			true,
+			[]string{"subscribe b"},
+			map[string]int{"a": 1, "b": 1},
+		},
+
+		{
+			"Removed",
+			[]string{"a", "b"},
+			[]string{"a"},
+			true,
+			[]string{"unsubscribe b"},
+			map[string]int{"a": 1, "b": 0},
+		},
+
+		{
+			"Replaced",
+			[]string{"a"},
+			[]string{"b"},
+			true,
+			[]string{"unsubscribe a", "subscribe b"},
+			map[string]int{"a": 0, "b": 1},
+		},
+
+		{
+			"DuplicateRemoved",
+			[]string{"a", "a"},
+			[]string{"a"},
+			true,
+			nil,
+			map[string]int{"a": 1},
+		},
+
+		{
+			"Disabled",
+			[]string{"a", "b"},
+			[]string{"a", "b"},
+			false,
+			[]string{"unsubscribe a", "unsubscribe b"},
+			map[string]int{"a": 0, "b": 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeClient()
+
+			h, err := NewHulk(client, "")
+			assert.NoError(t, err)
+
+			s, err := newService(h, "devices", Manifest{Topics: tc.subscribed})
+			assert.NoError(t, err)
+
+			for _, topic := range tc.subscribed {
+				assert.NoError(t, h.subscribe(topic, s))
+			}
+
+			client.calls = nil
+
+			s.enabled = tc.enabled
+			s.topics = tc.topics
+			s.updateSubscriptions(tc.subscribed)
+
+			assert.Equal(t, tc.expectedCalls, client.calls)
+
+			for topic, count := range tc.expectedHandlers {
+				assert.Len(t, h.handlers[topic], count, topic)
+			}
+		})
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	rules := HookRules{
+		{Name: "device", Topic: "devices/{+id}/reboot", Log: &LogAction{Message: "reboot {id}"}},
+		{Name: "any", Log: &LogAction{Message: "{TOPIC}"}},
+		{Name: "sensor", Topic: "sensors/+", Log: &LogAction{Message: "{TOPIC}"}},
+	}
+
+	testCases := []struct {
+		name          string
+		mode          string
+		topic         string
+		expectedRules []string
+	}{
+		{"DefaultFirst", "", "devices/1/reboot", []string{"device"}},
+		{"First", HookModeFirst, "devices/1/reboot", []string{"device"}},
+		{"FirstSkipsUnmatched", HookModeFirst, "sensors/temp", []string{"any"}},
+		{"All", HookModeAll, "devices/1/reboot", []string{"device", "any"}},
+		{"AllSkipsUnmatched", HookModeAll, "sensors/temp", []string{"any", "sensor"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := NewHulk(newFakeClient(), "")
+			assert.NoError(t, err)
+
+			manifest := Manifest{
+				Topics: []string{"devices/+/reboot", "sensors/+"},
+				Hooks:  ManifestHooks{OnReceive: rules, OnReceiveMode: tc.mode},
+			}
+
+			s, err := newService(h, "devices", manifest)
+			assert.NoError(t, err)
+
+			s.enabled = true
+			s.expandTopics()
+
+			names := []string{}
+			for _, m := range s.matchRules(OnReceiveHook, tc.topic, nil) {
+				names = append(names, m.rule.name())
+			}
+
+			assert.Equal(t, tc.expectedRules, names)
+		})
+	}
+}
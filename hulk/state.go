@@ -0,0 +1,84 @@
+package hulk
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// state represents the daemon state persisted across restarts
+type state struct {
+	// Disabled lists the administratively disabled services
+	Disabled []string `json:"Disabled"`
+}
+
+// SetStateFile sets the file where the administrative state of services is persisted and loads it,
+// it must be called before loading services
+func (h *Hulk) SetStateFile(file string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.stateFile = file
+	h.disabled = map[string]bool{}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	st := state{}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return errors.Wrapf(err, "%s: invalid state file", file)
+	}
+
+	for _, name := range st.Disabled {
+		h.disabled[name] = true
+	}
+
+	return nil
+}
+
+// saveState persists the administrative state of services if there is a state file
+func (h *Hulk) saveState() error {
+	if h.stateFile == "" {
+		return nil
+	}
+
+	st := state{Disabled: []string{}}
+	for name := range h.disabled {
+		st.Disabled = append(st.Disabled, name)
+	}
+
+	sort.Strings(st.Disabled)
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.stateFile), 0755); err != nil {
+		return errors.Wrap(err, "failed to save state")
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated state file
+	tmp := h.stateFile + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "failed to save state")
+	}
+
+	if err := os.Rename(tmp, h.stateFile); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to save state")
+	}
+
+	return nil
+}
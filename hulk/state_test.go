@@ -0,0 +1,95 @@
+package hulk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OSSystems/hulk/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// newStateHulk returns a hulk with services a and b loaded, persisting its state to file
+func newStateHulk(t *testing.T, file string) *Hulk {
+	h, err := NewHulk(newFakeClient(), "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.SetStateFile(file))
+
+	assert.NoError(t, h.AddService("a", Manifest{Topics: []string{"a"}}))
+	assert.NoError(t, h.AddService("b", Manifest{Topics: []string{"b"}}))
+	assert.NoError(t, h.LoadServices())
+
+	return h
+}
+
+func TestStateRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "state", "state.json")
+
+	h := newStateHulk(t, file)
+	assert.NoError(t, h.DisableService("a"))
+
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"Disabled\": [\n    \"a\"\n  ]\n}", string(data))
+
+	// The disabled service stays disabled once restarted
+	h = newStateHulk(t, file)
+	assert.Equal(t, map[string]bool{"a": false, "b": true}, enabledServices(h))
+	assert.Equal(t, types.StatusReasonAdminDisabled, h.service("a").status.Reason)
+
+	assert.NoError(t, h.EnableService("a"))
+
+	h = newStateHulk(t, file)
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, enabledServices(h))
+}
+
+func TestStateSaveError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	// The state directory cannot be created under a regular file
+	parent := filepath.Join(dir, "file")
+	assert.NoError(t, ioutil.WriteFile(parent, nil, 0644))
+
+	h := newStateHulk(t, "")
+	h.stateFile = filepath.Join(parent, "state.json")
+
+	err = h.DisableService("a")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to save state")
+
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, enabledServices(h))
+}
+
+func TestStateInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hulk")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "state.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("{"), 0644))
+
+	h, err := NewHulk(newFakeClient(), "")
+	assert.NoError(t, err)
+
+	assert.EqualError(t, h.SetStateFile(file), file+": invalid state file: unexpected end of JSON input")
+}
+
+func TestStateWithoutFile(t *testing.T) {
+	h := newStateHulk(t, "")
+
+	assert.NoError(t, h.DisableService("a"))
+	assert.Equal(t, map[string]bool{"a": false, "b": true}, enabledServices(h))
+
+	assert.Equal(t, ErrServiceNotFound, h.DisableService("c"))
+}
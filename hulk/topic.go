@@ -0,0 +1,133 @@
+package hulk
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// captureRegexp matches a topic segment with a named capture like {+device} or {#path}
+var captureRegexp = regexp.MustCompile(`^{(?P<wildcard>[+#])(?P<name>[a-zA-Z_][a-zA-Z0-9_]*)?}$`)
+
+// validateTopic checks the MQTT syntax of a topic, which may contain named captures
+func validateTopic(topic string) error {
+	if topic == "" {
+		return errors.New("topic is empty")
+	}
+
+	segments := strings.Split(topic, "/")
+
+	for i, segment := range segments {
+		if m := captureRegexp.FindStringSubmatch(segment); m != nil {
+			segment = m[1]
+		}
+
+		switch {
+		case segment == "#":
+			if i != len(segments)-1 {
+				return errors.New("multi-level wildcard # must be the last level")
+			}
+		case segment == "+":
+		case strings.ContainsAny(segment, "+#"):
+			return errors.New("wildcards must occupy an entire level")
+		}
+	}
+
+	return nil
+}
+
+// topicPattern represents a topic with named segment captures
+type topicPattern struct {
+	// filter is the MQTT topic filter used to subscribe
+	filter   string
+	segments []string
+	captures map[int]string
+}
+
+// parseTopicPattern parses a topic that may contain named captures,
+// {+name} captures a single level and {#name} captures the remaining levels
+func parseTopicPattern(topic string) *topicPattern {
+	p := &topicPattern{
+		segments: strings.Split(topic, "/"),
+		captures: make(map[int]string),
+	}
+
+	for i, segment := range p.segments {
+		m := captureRegexp.FindStringSubmatch(segment)
+		if m == nil {
+			continue
+		}
+
+		p.segments[i] = m[1]
+
+		if m[2] != "" {
+			p.captures[i] = m[2]
+		}
+	}
+
+	p.filter = strings.Join(p.segments, "/")
+
+	return p
+}
+
+// checkCaptures checks that the names captured by the pattern do not collide with the
+// hook variables or with the variables of envs, which the captures would override
+func (p *topicPattern) checkCaptures(envs ...map[string]string) error {
+	for i := range p.segments {
+		name, ok := p.captures[i]
+		if !ok {
+			continue
+		}
+
+		if name == "TOPIC" || name == PayloadFileVariable || isPayloadVariable(name) {
+			return errors.Errorf("capture %s collides with a hook variable", name)
+		}
+
+		for _, env := range envs {
+			if _, ok := env[name]; ok {
+				return errors.Errorf("capture %s collides with an environment variable", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// match matches topic against the pattern and returns the captured segments
+func (p *topicPattern) match(topic string) (map[string]string, bool) {
+	levels := strings.Split(topic, "/")
+	captures := map[string]string{}
+
+	for i, segment := range p.segments {
+		if segment == "#" {
+			if name, ok := p.captures[i]; ok {
+				captures[name] = strings.Join(levels[i:], "/")
+			}
+
+			return captures, true
+		}
+
+		if i >= len(levels) {
+			return nil, false
+		}
+
+		if segment == "+" {
+			if name, ok := p.captures[i]; ok {
+				captures[name] = levels[i]
+			}
+
+			continue
+		}
+
+		if segment != levels[i] {
+			return nil, false
+		}
+	}
+
+	if len(levels) != len(p.segments) {
+		return nil, false
+	}
+
+	return captures, true
+}
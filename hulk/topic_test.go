@@ -0,0 +1,268 @@
+package hulk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTopic(t *testing.T) {
+	testCases := []struct {
+		name          string
+		topic         string
+		expectedError string
+	}{
+		{
+			"Plain",
+			"devices/1/reboot",
+			"",
+		},
+
+		{
+			"Wildcards",
+			"devices/+/#",
+			"",
+		},
+
+		{
+			"Captures",
+			"devices/{+device}/{#path}",
+			"",
+		},
+
+		{
+			"Empty",
+			"",
+			"topic is empty",
+		},
+
+		{
+			"MultiLevelNotLast",
+			"devices/#/reboot",
+			"multi-level wildcard # must be the last level",
+		},
+
+		{
+			"CaptureMultiLevelNotLast",
+			"devices/{#path}/reboot",
+			"multi-level wildcard # must be the last level",
+		},
+
+		{
+			"PartialWildcard",
+			"devices/dev+/reboot",
+			"wildcards must occupy an entire level",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateTopic(tc.topic)
+
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestParseTopicPattern(t *testing.T) {
+	testCases := []struct {
+		name             string
+		topic            string
+		expectedFilter   string
+		expectedCaptures map[int]string
+	}{
+		{
+			"Plain",
+			"devices/1/reboot",
+			"devices/1/reboot",
+			map[int]string{},
+		},
+
+		{
+			"Wildcards",
+			"devices/+/#",
+			"devices/+/#",
+			map[int]string{},
+		},
+
+		{
+			"SingleLevelCapture",
+			"devices/{+device}/reboot",
+			"devices/+/reboot",
+			map[int]string{1: "device"},
+		},
+
+		{
+			"MultiLevelCapture",
+			"devices/{+device}/{#path}",
+			"devices/+/#",
+			map[int]string{1: "device", 2: "path"},
+		},
+
+		{
+			"UnnamedCapture",
+			"devices/{+}/reboot",
+			"devices/+/reboot",
+			map[int]string{},
+		},
+
+		{
+			"NotCapture",
+			"devices/{device}/reboot",
+			"devices/{device}/reboot",
+			map[int]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pattern := parseTopicPattern(tc.topic)
+
+			assert.Equal(t, tc.expectedFilter, pattern.filter)
+			assert.Equal(t, tc.expectedCaptures, pattern.captures)
+		})
+	}
+}
+
+func TestTopicPatternMatch(t *testing.T) {
+	testCases := []struct {
+		name             string
+		pattern          string
+		topic            string
+		expectedMatch    bool
+		expectedCaptures map[string]string
+	}{
+		{
+			"Plain",
+			"devices/1/reboot",
+			"devices/1/reboot",
+			true,
+			map[string]string{},
+		},
+
+		{
+			"PlainMismatch",
+			"devices/1/reboot",
+			"devices/2/reboot",
+			false,
+			nil,
+		},
+
+		{
+			"SingleLevelCapture",
+			"devices/{+device}/reboot",
+			"devices/1/reboot",
+			true,
+			map[string]string{"device": "1"},
+		},
+
+		{
+			"MultiLevelCapture",
+			"devices/{+device}/{#path}",
+			"devices/1/config/network",
+			true,
+			map[string]string{"device": "1", "path": "config/network"},
+		},
+
+		{
+			"MultiLevelCaptureParent",
+			"devices/{#path}",
+			"devices",
+			true,
+			map[string]string{"path": ""},
+		},
+
+		{
+			"TooFewLevels",
+			"devices/+/reboot",
+			"devices/1",
+			false,
+			nil,
+		},
+
+		{
+			"TooManyLevels",
+			"devices/+/reboot",
+			"devices/1/reboot/now",
+			false,
+			nil,
+		},
+
+		{
+			"EmptyLevel",
+			"devices/{+device}/reboot",
+			"devices//reboot",
+			true,
+			map[string]string{"device": ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			captures, ok := parseTopicPattern(tc.pattern).match(tc.topic)
+
+			assert.Equal(t, tc.expectedMatch, ok)
+			assert.Equal(t, tc.expectedCaptures, captures)
+		})
+	}
+}
+
+func TestTopicPatternCheckCaptures(t *testing.T) {
+	testCases := []struct {
+		name          string
+		pattern       string
+		environment   map[string]string
+		expectedError string
+	}{
+		{
+			"NoCollision",
+			"devices/{+device}/{#path}",
+			map[string]string{"HOME": "/root"},
+			"",
+		},
+
+		{
+			"Environment",
+			"devices/{+HOME}/reboot",
+			map[string]string{"HOME": "/root"},
+			"capture HOME collides with an environment variable",
+		},
+
+		{
+			"Topic",
+			"devices/{+TOPIC}/reboot",
+			map[string]string{},
+			"capture TOPIC collides with a hook variable",
+		},
+
+		{
+			"PayloadFile",
+			"devices/{+HULK_PAYLOAD_FILE}/reboot",
+			map[string]string{},
+			"capture HULK_PAYLOAD_FILE collides with a hook variable",
+		},
+
+		{
+			"Payload",
+			"devices/{#payload}",
+			map[string]string{},
+			"capture payload collides with a hook variable",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := parseTopicPattern(tc.pattern).checkCaptures(tc.environment)
+
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedError)
+			}
+		})
+	}
+}
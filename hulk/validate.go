@@ -0,0 +1,312 @@
+package hulk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/OSSystems/hulk/api/types"
+	"github.com/OSSystems/hulk/template"
+	"github.com/pkg/errors"
+)
+
+// ValidateManifests validates manifest files without loading them and returns a result per file
+//
+// Besides the errors which prevent a service from loading, it reports services
+// without hook rules, invalid topics and templates and missing environment files.
+func (h *Hulk) ValidateManifests(files ...string) []*types.ValidationResult {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return validationResults(h.validateManifests(files))
+}
+
+// Check validates the manifest files of the services directory like ValidateManifests,
+// also reporting required services which do not exist
+func (h *Hulk) Check() ([]*types.ValidationResult, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	files := []string{}
+
+	if h.path != "" {
+		var err error
+		if files, err = filepath.Glob(filepath.Join(h.path, "*.yaml")); err != nil {
+			return nil, err
+		}
+	}
+
+	validations := h.validateManifests(files)
+
+	names := map[string]bool{}
+	for _, v := range validations {
+		names[serviceName(v.file)] = true
+	}
+
+	for _, definition := range h.definitions {
+		names[definition.name] = true
+	}
+
+	for _, v := range validations {
+		if v.service == nil {
+			continue
+		}
+
+		for _, name := range v.service.manifest.Requires {
+			if !names[name] {
+				v.add(name, errors.Errorf("required service %s does not exist", name))
+			}
+		}
+	}
+
+	return validationResults(validations), nil
+}
+
+// manifestValidation represents the validation of a manifest file
+type manifestValidation struct {
+	file string
+	data []byte
+	// service is nil if the manifest could not be loaded
+	service *Service
+	errors  []*ManifestError
+}
+
+// add adds an error located at the first occurrence of text in the manifest
+func (v *manifestValidation) add(text string, err error) {
+	line, column := locate(v.data, text)
+
+	v.errors = append(v.errors, &ManifestError{File: v.file, Line: line, Column: column, Err: err})
+}
+
+// validateManifests validates manifest files, also checking dependency cycles between them
+func (h *Hulk) validateManifests(files []string) []*manifestValidation {
+	validations := []*manifestValidation{}
+	services := []*Service{}
+
+	for _, file := range files {
+		v := h.validateManifest(file)
+		validations = append(validations, v)
+
+		if v.service != nil {
+			services = append(services, v.service)
+		}
+	}
+
+	_, cycles := sortServices(services)
+
+	for _, v := range validations {
+		if v.service == nil {
+			continue
+		}
+
+		if cycle := serviceCycle(cycles, v.service.name); cycle != nil {
+			v.errors = append(v.errors, &ManifestError{File: v.file, Err: errors.New(cycleStatus(cycle).Message)})
+		}
+	}
+
+	return validations
+}
+
+// validateManifest validates a manifest file
+func (h *Hulk) validateManifest(file string) *manifestValidation {
+	v := &manifestValidation{file: file}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		v.errors = append(v.errors, &ManifestError{File: file, Err: err})
+		return v
+	}
+
+	v.data = data
+
+	manifest, err := LoadManifest(data)
+	if err != nil {
+		v.errors = append(v.errors, newManifestError(file, data, err))
+		return v
+	}
+
+	if v.service, err = newService(h, serviceName(file), manifest); err != nil {
+		// Template syntax errors are reported with their location by check
+		if _, ok := errors.Cause(err).(*template.SyntaxError); !ok {
+			v.errors = append(v.errors, &ManifestError{File: file, Err: err})
+		}
+	}
+
+	v.check(manifest)
+
+	return v
+}
+
+// check performs the semantic checks which are not required to load the manifest
+func (v *manifestValidation) check(manifest Manifest) {
+	if len(manifest.Hooks.OnReceive) == 0 {
+		v.add("", errors.New("no OnReceive hook rules"))
+	}
+
+	for _, topic := range manifest.Topics {
+		v.checkTopic("Topics", topic)
+	}
+
+	v.checkTemplates("Environment", values(manifest.Environment)...)
+	v.checkTemplates("WorkingDirectory", manifest.WorkingDirectory)
+
+	for i, rule := range manifest.Hooks.OnReceive {
+		name := "OnReceive rule " + hookRuleName(i, rule)
+
+		if !hasAction(rule) {
+			v.add(rule.Name, errors.Errorf("%s has no action", name))
+		}
+
+		if rule.Topic != "" {
+			v.checkTopic(name+" Topic", rule.Topic)
+		}
+
+		// The command template is located by its text in the manifest, not by its escaped form
+		if err := template.Validate(commandTemplate(rule.Command)); err != nil {
+			v.add(rule.Command, errors.Wrapf(err, "invalid %s Command template", name))
+		}
+
+		v.checkTemplates(name+" Exec", rule.Exec...)
+		v.checkTemplates(name+" WorkingDirectory", rule.WorkingDirectory)
+		v.checkTemplates(name+" Environment", values(rule.Environment)...)
+
+		if rule.HTTP != nil {
+			v.checkTemplates(name+" HTTP", append([]string{rule.HTTP.URL, rule.HTTP.Body}, values(rule.HTTP.Headers)...)...)
+		}
+
+		if rule.File != nil {
+			v.checkTemplates(name+" File", rule.File.Path)
+		}
+
+		if rule.Publish != nil {
+			v.checkTemplates(name+" Publish", rule.Publish.Topic, rule.Publish.Payload)
+		}
+
+		if rule.Log != nil {
+			v.checkTemplates(name+" Log", rule.Log.Message)
+		}
+	}
+
+	for _, file := range manifest.EnvironmentFiles {
+		if _, err := os.Stat(file); err != nil {
+			v.add(file, errors.Errorf("environment file %s does not exist", file))
+		}
+	}
+}
+
+// checkTopic checks the syntax of a topic and its templates
+func (v *manifestValidation) checkTopic(field, topic string) {
+	if err := validateTopic(topic); err != nil {
+		v.add(topic, errors.Wrapf(err, "invalid %s %s", field, topic))
+		return
+	}
+
+	v.checkTemplates(field, topic)
+}
+
+// checkTemplates checks the syntax of the templates of field
+func (v *manifestValidation) checkTemplates(field string, templates ...string) {
+	for _, content := range templates {
+		if err := template.Validate(content); err != nil {
+			v.add(content, errors.Wrapf(err, "invalid %s template", field))
+		}
+	}
+}
+
+// WriteValidationResults writes results as JSON or as a "file:line:column: message" line per error
+// and returns the number of invalid files
+func WriteValidationResults(w io.Writer, results []*types.ValidationResult, asJSON bool) (int, error) {
+	invalid := 0
+
+	for _, result := range results {
+		if !result.Valid {
+			invalid++
+		}
+	}
+
+	if asJSON {
+		output, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return invalid, err
+		}
+
+		_, err = fmt.Fprintln(w, string(output))
+
+		return invalid, err
+	}
+
+	for _, result := range results {
+		for _, e := range result.Errors {
+			err := &ManifestError{File: result.File, Line: e.Line, Column: e.Column, Err: errors.New(e.Message)}
+
+			if _, err := fmt.Fprintln(w, err); err != nil {
+				return invalid, err
+			}
+		}
+	}
+
+	return invalid, nil
+}
+
+// validationResults converts validations to API type
+func validationResults(validations []*manifestValidation) []*types.ValidationResult {
+	results := []*types.ValidationResult{}
+
+	for _, v := range validations {
+		result := &types.ValidationResult{
+			File:   v.file,
+			Valid:  len(v.errors) == 0,
+			Errors: []*types.ValidationError{},
+		}
+
+		for _, err := range v.errors {
+			result.Errors = append(result.Errors, &types.ValidationError{
+				Line:    err.Line,
+				Column:  err.Column,
+				Message: err.Err.Error(),
+			})
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// locate returns the line and column of the first occurrence of text in data
+// or zeros if text is empty or not found
+func locate(data []byte, text string) (int, int) {
+	if text == "" {
+		return 0, 0
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		if column := strings.Index(line, text); column >= 0 {
+			return i + 1, column + 1
+		}
+	}
+
+	return 0, 0
+}
+
+// values returns the values of m sorted by key
+func values(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	list := []string{}
+	for _, key := range keys {
+		list = append(list, m[key])
+	}
+
+	return list
+}
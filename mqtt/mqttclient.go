@@ -6,6 +6,7 @@ type MqttClient interface {
 	IsConnected() bool
 	Subscribe(topic string, qos byte, callback MqttMessageHandler) error
 	Unsubscribe(topic string)
+	Publish(topic string, qos byte, retained bool, payload []byte) error
 }
 
 type MqttMessageHandler func(topic string, payload []byte)
@@ -8,6 +8,7 @@ type PahoClient interface {
 	IsConnected() bool
 	Subscribe(topic string, qos byte, callback MqttMessageHandler) error
 	Unsubscribe(topic string)
+	Publish(topic string, qos byte, retained bool, payload []byte) error
 }
 
 type pahoClient struct {
@@ -50,3 +51,10 @@ func (paho pahoClient) Subscribe(topic string, qos byte, callback MqttMessageHan
 func (paho pahoClient) Unsubscribe(topic string) {
 	paho.mqtt.Unsubscribe(topic)
 }
+
+func (paho pahoClient) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	token := paho.mqtt.Publish(topic, qos, retained, payload)
+	token.Wait()
+
+	return token.Error()
+}
@@ -4,19 +4,77 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/OSSystems/pkg/log"
 	"github.com/fsnotify/fsnotify"
 )
 
+// DefaultDebounce is the debounce window of files added by Add
+const DefaultDebounce = 100 * time.Millisecond
+
+// EventType represents the type of change of a watched file
+type EventType int
+
+// Event types
+const (
+	// Created means the file was created
+	Created EventType = iota
+	// Modified means the file was written or replaced
+	Modified
+	// Removed means the file was removed or renamed away
+	Removed
+)
+
+var eventTypeNames = map[EventType]string{
+	Created:  "created",
+	Modified: "modified",
+	Removed:  "removed",
+}
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	return eventTypeNames[t]
+}
+
+// Event represents a change of a watched file
+type Event struct {
+	// Name is the name the file was added with
+	Name string
+	Type EventType
+}
+
 // FileWatcher is a file watcher
+//
+// The parent directory of each file is watched instead of the file itself, so files
+// replaced by renaming a temporary file over them are still watched after the rename.
+// Symbolic links are followed: the directory of the link target is also watched and
+// a change of the target, like the '..data' symbolic link swap of Kubernetes volumes,
+// is a modification of the file.
 type FileWatcher struct {
-	// Changed notifies when file is created or modified
-	Changed chan string
+	// Events notifies when a file is created, modified or removed
+	Events chan Event
 
 	watcher *fsnotify.Watcher
-	files   map[string]bool
-	cancel  chan bool
+	// lock guards files, which is changed by Add while watching
+	lock   sync.Mutex
+	files  map[string]*watchedFile
+	cancel chan bool
+}
+
+// watchedFile represents a watched file
+type watchedFile struct {
+	path string
+	// names are the names the file was added with, notified on changes
+	names []string
+	// debounce is the time without events to wait before notifying a change
+	debounce time.Duration
+	timer    *time.Timer
+	// exists and target are the file state when the last event was notified,
+	// target is the path of the file with symbolic links resolved, see resolveLinks
+	exists bool
+	target string
 }
 
 // NewFileWatcher initializes a new FileWatcher
@@ -28,43 +86,97 @@ func NewFileWatcher() (*FileWatcher, error) {
 
 	return &FileWatcher{
 		watcher: watcher,
-		files:   make(map[string]bool),
+		files:   make(map[string]*watchedFile),
 		cancel:  make(chan bool),
-		Changed: make(chan string),
+		Events:  make(chan Event),
 	}, nil
 }
 
-// Add starts watching filename
+// Add starts watching filename with the default debounce window
 func (fw *FileWatcher) Add(filename string) error {
-	path := filename
-	parent := filepath.Dir(filename)
-	exists := true
-
-	for {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			if path == parent {
-				return errors.New("Parent directory does not exist")
-			}
-
-			exists = false
-			path = filepath.Dir(path)
+	return fw.AddDebounced(filename, DefaultDebounce)
+}
 
-			continue
-		}
+// AddDebounced starts watching filename, notifying a change once no event was received
+// for the debounce window, so a file written in several steps is notified only once
+func (fw *FileWatcher) AddDebounced(filename string, debounce time.Duration) error {
+	path := filepath.Clean(filename)
+	parent := filepath.Dir(path)
 
-		break
+	if _, err := os.Stat(parent); os.IsNotExist(err) {
+		return errors.New("Parent directory does not exist")
 	}
 
-	err := fw.watcher.Add(path)
-	if err != nil {
+	if err := fw.watcher.Add(parent); err != nil {
 		return err
 	}
 
-	fw.files[filename] = exists
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+
+	file, ok := fw.files[path]
+	if !ok {
+		file = &watchedFile{path: path}
+		file.exists, file.target = fw.resolve(path)
+		fw.files[path] = file
+	}
+
+	file.debounce = debounce
+
+	for _, name := range file.names {
+		if name == filename {
+			return nil
+		}
+	}
+
+	file.names = append(file.names, filename)
 
 	return nil
 }
 
+// resolve returns whether the file at path exists and its path with symbolic links resolved,
+// watching the directory of the resolved path
+func (fw *FileWatcher) resolve(path string) (bool, string) {
+	exists, target := resolveLinks(path)
+
+	if filepath.Dir(target) != filepath.Dir(path) {
+		if err := fw.watcher.Add(filepath.Dir(target)); err != nil && !os.IsNotExist(err) {
+			log.Warn(err)
+		}
+	}
+
+	return exists, target
+}
+
+// resolveLinks returns whether the file at path exists and its path with symbolic links
+// resolved, the path of a dangling link is the path it points to
+func resolveLinks(path string) (bool, string) {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		return true, target
+	}
+
+	// Follow the links as far as possible, limiting the depth like the kernel does
+	for i := 0; i < 40; i++ {
+		info, err := os.Lstat(path)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			break
+		}
+
+		link, err := os.Readlink(path)
+		if err != nil {
+			break
+		}
+
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+
+		path = filepath.Clean(link)
+	}
+
+	return false, path
+}
+
 // Watch watches for file changes
 func (fw *FileWatcher) Watch() {
 	go func() {
@@ -73,12 +185,7 @@ func (fw *FileWatcher) Watch() {
 			case <-fw.cancel:
 				break
 			case event := <-fw.watcher.Events:
-				if event.Op == fsnotify.Write || event.Op == fsnotify.Create {
-					if _, ok := fw.files[event.Name]; ok {
-						fw.Changed <- event.Name
-						fw.files[event.Name] = true
-					}
-				}
+				fw.handle(event)
 			case err := <-fw.watcher.Errors:
 				log.Error(err)
 			}
@@ -87,3 +194,73 @@ func (fw *FileWatcher) Watch() {
 
 	<-fw.cancel
 }
+
+// handle starts the debounce window of the watched files affected by event
+func (fw *FileWatcher) handle(event fsnotify.Event) {
+	name := filepath.Clean(event.Name)
+
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+
+	for _, file := range fw.files {
+		switch {
+		case name == file.path, name == file.target:
+			// Any operation on the file or its target, a rename over the file is a create
+		case filepath.Dir(name) == filepath.Dir(file.path) && event.Op&fsnotify.Chmod == 0:
+			// A symbolic link in the path of the file may have been swapped
+			if _, target := resolveLinks(file.path); target == file.target {
+				continue
+			}
+		default:
+			continue
+		}
+
+		fw.changed(file)
+	}
+}
+
+// changed notifies the change of file once its debounce window expires
+func (fw *FileWatcher) changed(file *watchedFile) {
+	if file.debounce <= 0 {
+		go fw.notify(file)
+		return
+	}
+
+	// Restart the window, the change is notified once the events stop
+	if file.timer != nil {
+		file.timer.Stop()
+	}
+
+	file.timer = time.AfterFunc(file.debounce, func() { fw.notify(file) })
+}
+
+// notify notifies the change of file, its type is given by the current file state
+func (fw *FileWatcher) notify(file *watchedFile) {
+	fw.lock.Lock()
+
+	existed := file.exists
+	file.exists, file.target = fw.resolve(file.path)
+
+	exists := file.exists
+	names := append([]string{}, file.names...)
+
+	fw.lock.Unlock()
+
+	var eventType EventType
+
+	switch {
+	case exists && existed:
+		eventType = Modified
+	case exists:
+		eventType = Created
+	case existed:
+		eventType = Removed
+	default:
+		// Created and removed again within the debounce window
+		return
+	}
+
+	for _, name := range names {
+		fw.Events <- Event{Name: name, Type: eventType}
+	}
+}
@@ -0,0 +1,253 @@
+package filewatcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const debounce = 50 * time.Millisecond
+
+func newTestWatcher(t *testing.T) (*FileWatcher, string) {
+	dir, err := ioutil.TempDir("", "filewatcher-test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// Resolve the temporary directory in case it is a symbolic link itself
+	if dir, err = filepath.EvalSymlinks(dir); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	fw, err := NewFileWatcher()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	go fw.Watch()
+
+	return fw, dir
+}
+
+// events collects the notified events until no event is notified for a while
+func events(fw *FileWatcher) []Event {
+	list := []Event{}
+
+	for {
+		select {
+		case event := <-fw.Events:
+			list = append(list, event)
+		case <-time.After(4 * debounce):
+			return list
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	testCases := []struct {
+		name           string
+		debounce       time.Duration
+		expectedEvents int
+	}{
+		{"Debounced", debounce, 1},
+		{"NotDebounced", 0, 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fw, dir := newTestWatcher(t)
+			defer os.RemoveAll(dir)
+
+			filename := filepath.Join(dir, "env")
+
+			assert.NoError(t, fw.AddDebounced(filename, tc.debounce))
+
+			file, err := os.Create(filename)
+			assert.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				time.Sleep(debounce / 5)
+				file.WriteString("A=1\n")
+			}
+
+			file.Close()
+
+			list := events(fw)
+
+			assert.Len(t, list, tc.expectedEvents)
+			for _, event := range list {
+				assert.Equal(t, filename, event.Name)
+			}
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	testCases := []struct {
+		name           string
+		setup          func(filename string)
+		change         func(filename string)
+		expectedEvents []EventType
+	}{
+		{
+			"Created",
+			func(filename string) {},
+			func(filename string) { ioutil.WriteFile(filename, []byte("A=1\n"), 0644) },
+			[]EventType{Created},
+		},
+
+		{
+			"Modified",
+			func(filename string) { ioutil.WriteFile(filename, []byte("A=1\n"), 0644) },
+			func(filename string) { ioutil.WriteFile(filename, []byte("A=2\n"), 0644) },
+			[]EventType{Modified},
+		},
+
+		{
+			"Removed",
+			func(filename string) { ioutil.WriteFile(filename, []byte("A=1\n"), 0644) },
+			func(filename string) { os.Remove(filename) },
+			[]EventType{Removed},
+		},
+
+		{
+			"RenamedAway",
+			func(filename string) { ioutil.WriteFile(filename, []byte("A=1\n"), 0644) },
+			func(filename string) { os.Rename(filename, filename+".old") },
+			[]EventType{Removed},
+		},
+
+		{
+			"AtomicRename",
+			func(filename string) { ioutil.WriteFile(filename, []byte("A=1\n"), 0644) },
+			func(filename string) {
+				ioutil.WriteFile(filename+".tmp", []byte("A=2\n"), 0644)
+				os.Rename(filename+".tmp", filename)
+			},
+			[]EventType{Modified},
+		},
+
+		{
+			"Chmod",
+			func(filename string) { ioutil.WriteFile(filename, []byte("A=1\n"), 0644) },
+			func(filename string) { os.Chmod(filename, 0600) },
+			[]EventType{Modified},
+		},
+
+		{
+			"CreatedAndRemoved",
+			func(filename string) {},
+			func(filename string) {
+				ioutil.WriteFile(filename, []byte("A=1\n"), 0644)
+				os.Remove(filename)
+			},
+			[]EventType{},
+		},
+
+		{
+			"OtherFile",
+			func(filename string) {},
+			func(filename string) { ioutil.WriteFile(filename+".other", []byte("A=1\n"), 0644) },
+			[]EventType{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fw, dir := newTestWatcher(t)
+			defer os.RemoveAll(dir)
+
+			filename := filepath.Join(dir, "env")
+
+			tc.setup(filename)
+			assert.NoError(t, fw.AddDebounced(filename, debounce))
+
+			tc.change(filename)
+
+			types := []EventType{}
+			for _, event := range events(fw) {
+				assert.Equal(t, filename, event.Name)
+				types = append(types, event.Type)
+			}
+
+			assert.Equal(t, tc.expectedEvents, types)
+		})
+	}
+}
+
+func TestMissingParent(t *testing.T) {
+	fw, dir := newTestWatcher(t)
+	defer os.RemoveAll(dir)
+
+	assert.EqualError(t, fw.Add(filepath.Join(dir, "missing", "env")), "Parent directory does not exist")
+}
+
+func TestSymlinkTarget(t *testing.T) {
+	fw, dir := newTestWatcher(t)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "data"), 0755))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "etc"), 0755))
+
+	target := filepath.Join(dir, "data", "env")
+	filename := filepath.Join(dir, "etc", "env")
+
+	assert.NoError(t, ioutil.WriteFile(target, []byte("A=1\n"), 0644))
+	assert.NoError(t, os.Symlink(target, filename))
+	assert.NoError(t, fw.AddDebounced(filename, debounce))
+
+	// Writes to the target in another directory are modifications of the link
+	assert.NoError(t, ioutil.WriteFile(target, []byte("A=2\n"), 0644))
+	assert.Equal(t, []Event{{Name: filename, Type: Modified}}, events(fw))
+
+	// A dangling link is a removed file
+	assert.NoError(t, os.Remove(target))
+	assert.Equal(t, []Event{{Name: filename, Type: Removed}}, events(fw))
+
+	assert.NoError(t, ioutil.WriteFile(target, []byte("A=3\n"), 0644))
+	assert.Equal(t, []Event{{Name: filename, Type: Created}}, events(fw))
+}
+
+// swapData mimics the atomic update of a Kubernetes ConfigMap volume: the files are
+// written to a new timestamped directory, the '..data' symbolic link is swapped to it
+// by a rename and the previous directory is removed
+func swapData(t *testing.T, dir, version, content string) {
+	data := filepath.Join(dir, "..data")
+	versionDir := filepath.Join(dir, version)
+
+	previous, _ := os.Readlink(data)
+
+	assert.NoError(t, os.Mkdir(versionDir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(versionDir, "env"), []byte(content), 0644))
+	assert.NoError(t, os.Symlink(version, filepath.Join(dir, "..data_tmp")))
+	assert.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), data))
+
+	if previous != "" {
+		assert.NoError(t, os.RemoveAll(filepath.Join(dir, previous)))
+	}
+}
+
+func TestSymlinkSwap(t *testing.T) {
+	fw, dir := newTestWatcher(t)
+	defer os.RemoveAll(dir)
+
+	swapData(t, dir, "..2018_01_01_00_00_00.1", "A=1\n")
+
+	filename := filepath.Join(dir, "env")
+	assert.NoError(t, os.Symlink(filepath.Join("..data", "env"), filename))
+	assert.NoError(t, fw.AddDebounced(filename, debounce))
+
+	for i, version := range []string{"..2018_01_01_00_00_00.2", "..2018_01_01_00_00_00.3"} {
+		swapData(t, dir, version, "A=2\n")
+
+		assert.Equal(t, []Event{{Name: filename, Type: Modified}}, events(fw), "swap %d", i)
+	}
+
+	// Removing the data link removes the file
+	assert.NoError(t, os.Remove(filepath.Join(dir, "..data")))
+	assert.Equal(t, []Event{{Name: filename, Type: Removed}}, events(fw))
+}
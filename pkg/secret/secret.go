@@ -0,0 +1,134 @@
+package secret
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Mask replaces secret values
+const Mask = "********"
+
+// minValueLength is the minimum length of a secret value to be masked inside
+// other strings, shorter values would mask unrelated text
+const minValueLength = 4
+
+// DefaultPatterns holds the name patterns of variables which are always secret
+var DefaultPatterns = []string{
+	"*PASSWORD*",
+	"*PASSWD*",
+	"*SECRET*",
+	"*TOKEN*",
+	"*AUTHORIZATION*",
+	"*CREDENTIAL*",
+	"*KEY",
+	"*_KEY_*",
+}
+
+// Masker masks the values of secret variables
+type Masker struct {
+	patterns []string
+}
+
+// NewMasker creates a new Masker which considers secret the variables whose
+// names match any of the case-insensitive glob patterns
+func NewMasker(patterns ...string) *Masker {
+	m := &Masker{}
+
+	for _, pattern := range patterns {
+		m.patterns = append(m.patterns, strings.ToUpper(pattern))
+	}
+
+	return m
+}
+
+// With returns a new Masker with the extra patterns
+func (m *Masker) With(patterns ...string) *Masker {
+	return NewMasker(append(append([]string{}, m.patterns...), patterns...)...)
+}
+
+// IsSecret returns whether the variable name holds a secret value
+func (m *Masker) IsSecret(name string) bool {
+	name = strings.ToUpper(name)
+
+	for _, pattern := range m.patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Value returns the value of the variable name masked if it is secret
+func (m *Masker) Value(name, value string) string {
+	if m.IsSecret(name) {
+		return Mask
+	}
+
+	return value
+}
+
+// Environment returns a copy of env with the values of secret variables masked
+func (m *Masker) Environment(env map[string]string) map[string]string {
+	masked := map[string]string{}
+
+	for key, value := range env {
+		masked[key] = m.Value(key, value)
+	}
+
+	return masked
+}
+
+// List returns a copy of a KEY=VALUE list with the values of secret variables masked
+func (m *Masker) List(list []string) []string {
+	masked := []string{}
+
+	for _, variable := range list {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) == 2 {
+			variable = parts[0] + "=" + m.Value(parts[0], parts[1])
+		}
+
+		masked = append(masked, variable)
+	}
+
+	return masked
+}
+
+// String masks the values of the secret variables of env found in s
+func (m *Masker) String(s string, env map[string]string) string {
+	values := []string{}
+
+	for key, value := range env {
+		if len(value) >= minValueLength && m.IsSecret(key) {
+			values = append(values, value)
+		}
+	}
+
+	// Replace the longest values first, so a value containing another one is fully masked
+	sort.Sort(byLength(values))
+
+	for _, value := range values {
+		s = strings.Replace(s, value, Mask, -1)
+	}
+
+	return s
+}
+
+// Strings masks the values of the secret variables of env found in each element of list
+func (m *Masker) Strings(list []string, env map[string]string) []string {
+	masked := []string{}
+
+	for _, s := range list {
+		masked = append(masked, m.String(s, env))
+	}
+
+	return masked
+}
+
+type byLength []string
+
+func (s byLength) Len() int           { return len(s) }
+func (s byLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLength) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
@@ -0,0 +1,60 @@
+package secret
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSecret(t *testing.T) {
+	testCases := []struct {
+		name           string
+		patterns       []string
+		variable       string
+		expectedResult bool
+	}{
+		{"Password", DefaultPatterns, "HULK_PASSWORD", true},
+		{"Authorization", DefaultPatterns, "AUTHORIZATION", true},
+		{"LowerCase", DefaultPatterns, "api_token", true},
+		{"Key", DefaultPatterns, "API_KEY", true},
+		{"NotSecret", DefaultPatterns, "DEVICE", false},
+		{"KeyPrefix", DefaultPatterns, "KEYBOARD", false},
+		{"CustomPattern", []string{"DEVICE_*"}, "DEVICE_SERIAL", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedResult, NewMasker(tc.patterns...).IsSecret(tc.variable))
+		})
+	}
+}
+
+func TestMaskEnvironment(t *testing.T) {
+	m := NewMasker(DefaultPatterns...).With("SERIAL")
+
+	env := map[string]string{
+		"DEVICE":        "device1",
+		"SERIAL":        "1234",
+		"AUTHORIZATION": "Bearer abcdef",
+	}
+
+	assert.Equal(t, map[string]string{
+		"DEVICE":        "device1",
+		"SERIAL":        Mask,
+		"AUTHORIZATION": Mask,
+	}, m.Environment(env))
+
+	assert.Equal(t, []string{"DEVICE=device1", "SERIAL=" + Mask, "INVALID"}, m.List([]string{"DEVICE=device1", "SERIAL=1234", "INVALID"}))
+}
+
+func TestMaskString(t *testing.T) {
+	m := NewMasker(DefaultPatterns...)
+
+	env := map[string]string{
+		"DEVICE":   "device1",
+		"TOKEN":    "abcdef",
+		"PASSWORD": "abc",
+	}
+
+	assert.Equal(t, "curl -H 'Authorization: "+Mask+"' http://example.com/device1 abc", m.String("curl -H 'Authorization: abcdef' http://example.com/device1 abc", env))
+}
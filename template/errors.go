@@ -1,5 +1,7 @@
 package template
 
+import "fmt"
+
 // Errors returned when expanding a variable
 var (
 	ErrRequiredValueNotFound = "No value for required variable"
@@ -10,6 +12,8 @@ var (
 type VariableExpandError struct {
 	Name       string
 	IsOptional bool
+	// Position is the byte offset of the variable placeholder in the template
+	Position int
 }
 
 // Error returns a string representation of an VariableExpandError
@@ -20,3 +24,15 @@ func (e *VariableExpandError) Error() string {
 
 	return ErrRequiredValueNotFound
 }
+
+// SyntaxError implements an error returned when a template is malformed
+type SyntaxError struct {
+	// Position is the byte offset of the malformed placeholder in the template
+	Position int
+	Message  string
+}
+
+// Error returns a string representation of a SyntaxError
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("template syntax error at position %d: %s", e.Position, e.Message)
+}
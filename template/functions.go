@@ -0,0 +1,114 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pipe is a function applied to a variable value, like |lower or |replace(":","")
+type pipe struct {
+	name string
+	args []string
+}
+
+// function implements a template function
+type function struct {
+	// arity is the number of arguments
+	arity int
+	// validate checks the arguments, it may be nil
+	validate func(args []string) error
+	apply    func(value string, args []string) string
+}
+
+// functions holds the functions available to templates
+var functions = map[string]*function{
+	"lower": {
+		apply: func(value string, args []string) string {
+			return strings.ToLower(value)
+		},
+	},
+	"upper": {
+		apply: func(value string, args []string) string {
+			return strings.ToUpper(value)
+		},
+	},
+	"trim": {
+		apply: func(value string, args []string) string {
+			return strings.TrimSpace(value)
+		},
+	},
+	"replace": {
+		arity: 2,
+		validate: func(args []string) error {
+			if args[0] == "" {
+				return fmt.Errorf("replace: empty string to replace")
+			}
+
+			return nil
+		},
+		apply: func(value string, args []string) string {
+			return strings.Replace(value, args[0], args[1], -1)
+		},
+	},
+	"sha256": {
+		apply: func(value string, args []string) string {
+			sum := sha256.Sum256([]byte(value))
+			return hex.EncodeToString(sum[:])
+		},
+	},
+	"prefix": {
+		arity: 1,
+		validate: func(args []string) error {
+			if n, err := strconv.Atoi(args[0]); err != nil || n < 0 {
+				return fmt.Errorf("prefix: invalid length: %s", args[0])
+			}
+
+			return nil
+		},
+		apply: func(value string, args []string) string {
+			n, _ := strconv.Atoi(args[0])
+			if n < len(value) {
+				return value[:n]
+			}
+
+			return value
+		},
+	},
+	"urlencode": {
+		apply: func(value string, args []string) string {
+			// QueryEscape encodes spaces as '+' and a literal '+' as %2B
+			return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+		},
+	},
+}
+
+// checkPipe checks that the function of p exists and accepts its arguments
+func checkPipe(p *pipe) error {
+	f, ok := functions[p.name]
+	if !ok {
+		return fmt.Errorf("unknown function: %s", p.name)
+	}
+
+	if len(p.args) != f.arity {
+		return fmt.Errorf("%s: expects %d arguments, got %d", p.name, f.arity, len(p.args))
+	}
+
+	if f.validate != nil {
+		return f.validate(p.args)
+	}
+
+	return nil
+}
+
+// applyPipes applies pipes to value, in order
+func applyPipes(pipes []*pipe, value string) string {
+	for _, p := range pipes {
+		value = functions[p.name].apply(value, p.args)
+	}
+
+	return value
+}
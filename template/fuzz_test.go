@@ -0,0 +1,33 @@
+//go:build go1.18
+// +build go1.18
+
+package template
+
+import (
+	"testing"
+)
+
+// FuzzParse checks that parsing and expanding never panic and that the parse errors agree,
+// run it with: go test -fuzz FuzzParse ./template
+func FuzzParse(f *testing.F) {
+	seeds := []string{
+		"",
+		"devices/{DEVICE}/{+device}",
+		"{AA[]}{BB[,]}",
+		"{@product}{AA[,]}/{BB[,]}",
+		"{DEVICE:-{AA[]}}",
+		"{DEVICE|lower|prefix(2)|replace(\"a\",\"b\")}?",
+		"{DEVICE:+{MODEL}-}",
+		"{{DEVICE}",
+		`{"a": {"b": "{DEVICE}"}}`,
+		"{D} {DEVICE|nosuch} {DEVICE",
+	}
+
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, content string) {
+		checkParse(t, content)
+	})
+}
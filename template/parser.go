@@ -0,0 +1,405 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errNotPlaceholder is returned when a brace does not start a variable placeholder
+var errNotPlaceholder = errors.New("not a variable placeholder")
+
+// node is a part of a parsed template, either a text or a *templateVariable
+type node interface{}
+
+// parser parses the variable placeholders of a template:
+//
+//	{NAME}            value of NAME, names may contain dots like {payload.device.id}
+//	{NAME[]}          NAME split into an array by spaces, {NAME[,]} splits by commas
+//	{NAME:-default}   value of NAME or default if NAME is unset or empty
+//	{NAME:+alternate} alternate if NAME is set and not empty, otherwise nothing
+//	{NAME|lower}      value of NAME passed through functions, like |trim|prefix(8)
+//	{NAME}?           optional variable, see VariableExpandError
+//
+// A template may start with a directive choosing how arrays are expanded, {@zip},
+// the default, or {@product}, see Expand.
+//
+// A doubled opening brace {{ is a literal brace, so {{DEVICE} is the text {DEVICE},
+// use Escape to escape text. Closing braces are always literal outside placeholders
+// and are never doubled, so {{"a": 1}} is the text {"a": 1}} while {{"a": 1} is
+// {"a": 1}. Braces which do not start a placeholder are also kept as text, as are
+// malformed placeholders up to their matching closing brace, along with the
+// placeholders nested in them, except when compiling or validating.
+//
+// Defaults and alternates are templates themselves, without arrays.
+// Functions come after the array separator and before the default or alternate,
+// they apply to each array element and to defaults and alternates.
+type parser struct {
+	content string
+	pos     int
+	// strict makes malformed placeholders return a SyntaxError instead of being kept as text
+	strict bool
+	// mode is the array expansion mode set by the template directive
+	mode string
+}
+
+// parse parses the template content
+func (p *parser) parse() ([]node, error) {
+	p.mode = modeZip
+
+	if err := p.parseDirective(); err != nil {
+		return nil, err
+	}
+
+	return p.parseNodes(false)
+}
+
+// parseDirective parses the directive at the start of the template, if any
+func (p *parser) parseDirective() error {
+	if !p.hasPrefix("{@") {
+		return nil
+	}
+
+	end := strings.IndexByte(p.content, '}')
+	if end < 0 {
+		if p.strict {
+			return &SyntaxError{Position: 0, Message: "unterminated directive"}
+		}
+
+		return nil
+	}
+
+	switch mode := p.content[2:end]; mode {
+	case modeZip, modeProduct:
+		p.mode = mode
+		p.pos = end + 1
+	default:
+		if p.strict {
+			return &SyntaxError{Position: 0, Message: fmt.Sprintf("unknown directive: @%s", mode)}
+		}
+	}
+
+	return nil
+}
+
+// parseNodes parses nodes until the end of content or, if nested, until an unmatched closing brace
+func (p *parser) parseNodes(nested bool) ([]node, error) {
+	nodes := []node{}
+	text := bytes.Buffer{}
+
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, text.String())
+			text.Reset()
+		}
+	}
+
+	for p.pos < len(p.content) {
+		c := p.content[p.pos]
+
+		if nested && c == '}' {
+			break
+		}
+
+		// An escaped brace is a literal brace
+		if p.hasPrefix("{{") {
+			text.WriteByte(c)
+			p.pos += 2
+			continue
+		}
+
+		if c == '{' {
+			start := p.pos
+
+			variable, err := p.parseVariable()
+			if err == nil {
+				flush()
+				nodes = append(nodes, variable)
+				continue
+			}
+
+			if _, ok := err.(*SyntaxError); ok {
+				if p.strict {
+					return nil, err
+				}
+
+				// A malformed placeholder is kept as text as a whole,
+				// so the placeholders nested in it are not expanded
+				p.pos = p.placeholderEnd(start)
+				text.WriteString(strings.Replace(p.content[start:p.pos], "{{", "{", -1))
+				continue
+			}
+
+			p.pos = start
+		}
+
+		text.WriteByte(c)
+		p.pos++
+	}
+
+	flush()
+
+	return nodes, nil
+}
+
+// placeholderEnd returns the position after the closing brace matching the opening brace
+// at start, or the position after the opening brace if there is none
+func (p *parser) placeholderEnd(start int) int {
+	depth := 0
+
+	for i := start; i < len(p.content); i++ {
+		switch {
+		case i > start && strings.HasPrefix(p.content[i:], "{{"):
+			i++
+		case p.content[i] == '{':
+			depth++
+		case p.content[i] == '}':
+			depth--
+
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return start + 1
+}
+
+// parseVariable parses a variable placeholder starting at the current opening brace
+func (p *parser) parseVariable() (*templateVariable, error) {
+	variable := &templateVariable{position: p.pos}
+
+	p.pos++
+
+	start := p.pos
+	for p.pos < len(p.content) && isNameChar(p.content[p.pos], p.pos == start) {
+		p.pos++
+	}
+
+	variable.name = p.content[start:p.pos]
+
+	if variable.name == "" {
+		return nil, errNotPlaceholder
+	}
+
+	if p.pos >= len(p.content) {
+		return nil, p.syntaxError(variable, "unterminated variable placeholder")
+	}
+
+	switch p.content[p.pos] {
+	case '}', '[', '|':
+	case ':':
+		if !p.hasPrefix(":-") && !p.hasPrefix(":+") {
+			return nil, errNotPlaceholder
+		}
+	default:
+		return nil, errNotPlaceholder
+	}
+
+	if len(variable.name) < 2 {
+		return nil, p.syntaxError(variable, fmt.Sprintf("variable name must have at least two characters: %s", variable.name))
+	}
+
+	if p.content[p.pos] == '[' {
+		if err := p.parseArray(variable); err != nil {
+			return nil, err
+		}
+	}
+
+	for p.pos < len(p.content) && p.content[p.pos] == '|' {
+		if err := p.parsePipe(variable); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.hasPrefix(":-") || p.hasPrefix(":+") {
+		if err := p.parseWord(variable); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.pos >= len(p.content) || p.content[p.pos] != '}' {
+		return nil, p.syntaxError(variable, "unterminated variable placeholder")
+	}
+
+	p.pos++
+
+	if p.pos < len(p.content) && p.content[p.pos] == '?' {
+		variable.isOptional = true
+		p.pos++
+	}
+
+	variable.end = p.pos
+
+	return variable, nil
+}
+
+// parseArray parses the array separator of variable, like [] or [,]
+func (p *parser) parseArray(variable *templateVariable) error {
+	end := strings.IndexByte(p.content[p.pos:], ']')
+
+	if end < 0 {
+		return p.syntaxError(variable, "unterminated variable placeholder")
+	}
+
+	if end > 2 {
+		return p.syntaxError(variable, "invalid array separator")
+	}
+
+	variable.isArray = true
+	variable.arraySeparator = p.content[p.pos+1 : p.pos+end]
+
+	p.pos += end + 1
+
+	return nil
+}
+
+// parsePipe parses a function applied to variable, like |lower or |replace(":","")
+func (p *parser) parsePipe(variable *templateVariable) error {
+	p.pos++
+
+	start := p.pos
+	for p.pos < len(p.content) && isNameChar(p.content[p.pos], p.pos == start) {
+		p.pos++
+	}
+
+	pipe := &pipe{name: p.content[start:p.pos]}
+
+	if pipe.name == "" {
+		return p.syntaxError(variable, "missing function name")
+	}
+
+	if p.pos < len(p.content) && p.content[p.pos] == '(' {
+		args, err := p.parseArgs(variable)
+		if err != nil {
+			return err
+		}
+
+		pipe.args = args
+	}
+
+	if err := checkPipe(pipe); err != nil {
+		return p.syntaxError(variable, err.Error())
+	}
+
+	variable.pipes = append(variable.pipes, pipe)
+
+	return nil
+}
+
+// parseArgs parses the function arguments starting at the current opening parenthesis,
+// arguments are double quoted strings, where \ escapes any character, or bare words
+func (p *parser) parseArgs(variable *templateVariable) ([]string, error) {
+	args := []string{}
+
+	p.pos++
+
+	for {
+		p.skipSpaces()
+
+		if p.pos >= len(p.content) {
+			return nil, p.syntaxError(variable, "unterminated function arguments")
+		}
+
+		if p.content[p.pos] == ')' && len(args) == 0 {
+			p.pos++
+			return args, nil
+		}
+
+		arg := bytes.Buffer{}
+
+		if p.content[p.pos] == '"' {
+			p.pos++
+
+			for p.pos < len(p.content) && p.content[p.pos] != '"' {
+				if p.content[p.pos] == '\\' && p.pos+1 < len(p.content) {
+					p.pos++
+				}
+
+				arg.WriteByte(p.content[p.pos])
+				p.pos++
+			}
+
+			if p.pos >= len(p.content) {
+				return nil, p.syntaxError(variable, "unterminated function argument string")
+			}
+
+			p.pos++
+		} else {
+			for p.pos < len(p.content) && strings.IndexByte(",) }", p.content[p.pos]) < 0 {
+				arg.WriteByte(p.content[p.pos])
+				p.pos++
+			}
+		}
+
+		args = append(args, arg.String())
+
+		p.skipSpaces()
+
+		if p.pos >= len(p.content) {
+			return nil, p.syntaxError(variable, "unterminated function arguments")
+		}
+
+		switch p.content[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return args, nil
+		default:
+			return nil, p.syntaxError(variable, "invalid function arguments")
+		}
+	}
+}
+
+// skipSpaces advances the current position past spaces
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.content) && p.content[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// parseWord parses the default or alternate of variable
+func (p *parser) parseWord(variable *templateVariable) error {
+	variable.modifier = p.content[p.pos+1]
+
+	p.pos += 2
+
+	word, err := p.parseNodes(true)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range word {
+		if v, ok := n.(*templateVariable); ok && v.isArray {
+			return p.syntaxError(v, "array variables are not allowed in defaults and alternates")
+		}
+	}
+
+	variable.word = word
+
+	return nil
+}
+
+// hasPrefix tells whether the content at the current position starts with prefix
+func (p *parser) hasPrefix(prefix string) bool {
+	return len(p.content)-p.pos >= len(prefix) && p.content[p.pos:p.pos+len(prefix)] == prefix
+}
+
+// syntaxError returns a SyntaxError for the placeholder of variable
+func (p *parser) syntaxError(variable *templateVariable, message string) error {
+	return &SyntaxError{Position: variable.position, Message: message}
+}
+
+// isNameChar tells whether c is valid in a variable name, digits and dots are not valid first characters
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		return true
+	case c >= '0' && c <= '9', c == '.':
+		return !first
+	}
+
+	return false
+}
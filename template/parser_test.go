@@ -0,0 +1,311 @@
+package template
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name          string
+		content       string
+		expectedNodes []node
+	}{
+		{
+			"Text",
+			"devices/status",
+			[]node{"devices/status"},
+		},
+
+		{
+			"Variable",
+			"devices/{DEVICE}/status",
+			[]node{
+				"devices/",
+				&templateVariable{position: 8, end: 16, name: "DEVICE"},
+				"/status",
+			},
+		},
+
+		{
+			"OptionalArray",
+			"{DEVICES[,]}?",
+			[]node{
+				&templateVariable{position: 0, end: 13, name: "DEVICES", isArray: true, arraySeparator: ",", isOptional: true},
+			},
+		},
+
+		{
+			"Default",
+			"{DEVICE:-unknown}",
+			[]node{
+				&templateVariable{position: 0, end: 17, name: "DEVICE", modifier: '-', word: []node{"unknown"}},
+			},
+		},
+
+		{
+			"EmptyDefault",
+			"{DEVICE:-}",
+			[]node{
+				&templateVariable{position: 0, end: 10, name: "DEVICE", modifier: '-', word: []node{}},
+			},
+		},
+
+		{
+			"NestedAlternate",
+			"a/{REGION:+region/{REGION}}",
+			[]node{
+				"a/",
+				&templateVariable{position: 2, end: 27, name: "REGION", modifier: '+', word: []node{
+					"region/",
+					&templateVariable{position: 18, end: 26, name: "REGION"},
+				}},
+			},
+		},
+
+		{
+			"Literals",
+			`{"key": 1} {+device} {a: b} {}`,
+			[]node{`{"key": 1} {+device} {a: b} {}`},
+		},
+
+		{
+			"MalformedAsText",
+			"{DEVICE",
+			[]node{"{DEVICE"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &parser{content: tc.content}
+
+			nodes, err := p.parse()
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedNodes, nodes)
+		})
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	testCases := []struct {
+		name          string
+		content       string
+		expectedError error
+	}{
+		{
+			"UnterminatedDefault",
+			"a/{DEVICE:-unknown",
+			errors.New("template syntax error at position 2: unterminated variable placeholder"),
+		},
+
+		{
+			"NestedUnterminated",
+			"{DEVICE:-{OTHER}",
+			errors.New("template syntax error at position 0: unterminated variable placeholder"),
+		},
+
+		{
+			"ArrayInDefault",
+			"{DEVICE:-{DEVICES[]}}",
+			errors.New("template syntax error at position 9: array variables are not allowed in defaults and alternates"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &parser{content: tc.content, strict: true}
+
+			_, err := p.parse()
+
+			assert.EqualError(t, err, tc.expectedError.Error())
+		})
+	}
+}
+
+func TestParsePipes(t *testing.T) {
+	testCases := []struct {
+		name          string
+		content       string
+		expectedPipes []*pipe
+		expectedError error
+	}{
+		{
+			"Single",
+			"{DEVICE|lower}",
+			[]*pipe{{name: "lower"}},
+			nil,
+		},
+
+		{
+			"Chain",
+			"{ID|sha256|prefix(8)}",
+			[]*pipe{{name: "sha256"}, {name: "prefix", args: []string{"8"}}},
+			nil,
+		},
+
+		{
+			"QuotedArgs",
+			`{MAC|replace(":", "")}`,
+			[]*pipe{{name: "replace", args: []string{":", ""}}},
+			nil,
+		},
+
+		{
+			"EscapedArgs",
+			`{MAC|replace("\"", "\\")}`,
+			[]*pipe{{name: "replace", args: []string{`"`, `\`}}},
+			nil,
+		},
+
+		{
+			"ArrayWithDefault",
+			"{DEVICES[,]|upper:-a,b}",
+			[]*pipe{{name: "upper"}},
+			nil,
+		},
+
+		{
+			"UnknownFunction",
+			"{DEVICE|reverse}",
+			nil,
+			errors.New("template syntax error at position 0: unknown function: reverse"),
+		},
+
+		{
+			"WrongArity",
+			"{DEVICE|prefix}",
+			nil,
+			errors.New("template syntax error at position 0: prefix: expects 1 arguments, got 0"),
+		},
+
+		{
+			"InvalidLength",
+			"{DEVICE|prefix(-1)}",
+			nil,
+			errors.New("template syntax error at position 0: prefix: invalid length: -1"),
+		},
+
+		{
+			"MissingName",
+			"{DEVICE|}",
+			nil,
+			errors.New("template syntax error at position 0: missing function name"),
+		},
+
+		{
+			"UnterminatedString",
+			`{MAC|replace(":}`,
+			nil,
+			errors.New("template syntax error at position 0: unterminated function argument string"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &parser{content: tc.content, strict: true}
+
+			nodes, err := p.parse()
+
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+				return
+			}
+
+			assert.NoError(t, err)
+			if assert.Len(t, nodes, 1) {
+				assert.Equal(t, tc.expectedPipes, nodes[0].(*templateVariable).pipes)
+			}
+		})
+	}
+}
+
+func TestParseEscape(t *testing.T) {
+	testCases := []struct {
+		name           string
+		content        string
+		expectedResult []string
+	}{
+		{"EscapedPlaceholder", "{{DEVICE}", []string{"{DEVICE}"}},
+		{"EscapedAndVariable", `{{"device": "{DEVICE}"}`, []string{`{"device": "a1"}`}},
+		{"BracedVariable", "{{{DEVICE}}", []string{"{a1}"}},
+		{"EscapedDirective", "{{@product}", []string{"{@product}"}},
+		{"EscapedJson", `{{"device": 1}`, []string{`{"device": 1}`}},
+		{"DoubledClosingBrace", `{{"device": 1}}`, []string{`{"device": 1}}`}},
+		{"ClosingBraceAfterVariable", "{DEVICE}}", []string{"a1}"}},
+		{"EscapedInDefault", "{MISSING:-{{DEVICE}}", []string{"{DEVICE}"}},
+		{"Json", `{"device": {"id": "{DEVICE}"}}`, []string{`{"device": {"id": "a1"}}`}},
+		{"InvalidPlaceholders", "{D} {DEVICE|unknown} {DEVICE[;;]} {DEVICE", []string{"{D} {DEVICE|unknown} {DEVICE[;;]} {DEVICE"}},
+		{"PlaceholderInsideInvalid", "{X{DEVICE}}", []string{"{Xa1}"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := Expand(tc.content, map[string]string{"DEVICE": "a1"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedResult, list)
+		})
+	}
+}
+
+// TestParseRandom checks the parser invariants on random templates, see FuzzParse
+func TestParseRandom(t *testing.T) {
+	tokens := []string{
+		"{", "{", "{", "}", "}", "{{", "{AB", "{X1", "[", "]", "[,]", "|", "|lower", "|prefix(2)",
+		"|replace(\"a\",\"b\")", "(", ")", ":-", ":+", "?", "@", "{@product}", ",", "\"", "\\", " ", "/", "a",
+	}
+
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		content := ""
+		for n := r.Intn(12); n > 0; n-- {
+			content += tokens[r.Intn(len(tokens))]
+		}
+
+		// Escaped text expands to itself
+		list, err := Expand(Escape(content), nil)
+		if !assert.NoError(t, err, content) || !assert.Equal(t, []string{content}, list, content) {
+			return
+		}
+
+		if !checkParse(t, content) {
+			return
+		}
+	}
+}
+
+// checkParse checks the invariants of parsing content
+func checkParse(t *testing.T, content string) bool {
+	tpl, err := Compile(content)
+
+	if !assert.Equal(t, err, Validate(content), content) {
+		return false
+	}
+
+	if err != nil {
+		_, ok := err.(*SyntaxError)
+
+		return assert.True(t, ok, content) &&
+			assert.NotPanics(t, func() { Expand(content, map[string]string{}) }, content)
+	}
+
+	// Valid templates parse the same in lenient mode
+	if !assert.Equal(t, tpl.nodes, compile(content).nodes, content) {
+		return false
+	}
+
+	values := map[string]string{}
+	for _, variable := range tpl.Variables() {
+		values[variable.Name] = "a,b"
+	}
+
+	// Expanding may fail, for instance when arrays differ in size, but it must not panic
+	return assert.NotPanics(t, func() { tpl.Expand(values) }, content) &&
+		assert.NotPanics(t, func() { tpl.Expand(map[string]string{}) }, content)
+}
@@ -1,142 +1,305 @@
 package template
 
 import (
+	"bytes"
 	"fmt"
-	"regexp"
 	"strings"
 )
 
-const templateRegexp = `{(?P<name>[a-zA-Z_][a-zA-Z0-9_]+)(?P<array>\[.?\])?}(?P<optional>\?)?`
+// Array expansion modes
+const (
+	// modeZip expands the arrays together, element by element
+	modeZip = "zip"
+	// modeProduct expands every combination of the array elements
+	modeProduct = "product"
+)
+
+// MaxExpansions is the maximum number of strings a template expands to
+var MaxExpansions = 1000
 
-type template struct {
-	content   string
-	variables []*templateVariable
+// Template represents a compiled template, it is safe for concurrent use
+type Template struct {
+	content string
+	nodes   []node
+	mode    string
 }
 
-func (t *template) parse() {
-	for _, v := range t.match() {
-		variable := &templateVariable{
-			name:       v["name"],
-			isArray:    v["array"] != "",
-			isOptional: v["optional"] != "",
-		}
+// Variable describes a variable placeholder of a template
+type Variable struct {
+	Name    string
+	IsArray bool
+	// Separator is the array separator, a space by default
+	Separator  string
+	IsOptional bool
+}
+
+// Compile parses template content, returning a SyntaxError if a placeholder is malformed
+func Compile(content string) (*Template, error) {
+	p := &parser{content: content, strict: true}
+
+	nodes, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{content: content, nodes: nodes, mode: p.mode}, nil
+}
 
-		if variable.isArray {
-			if v["array"] != "[]" {
-				variable.arraySeparator = string(v["array"][1])
+// compile parses template content keeping malformed placeholders as text
+func compile(content string) *Template {
+	p := &parser{content: content}
+
+	// A lenient parser never fails
+	nodes, _ := p.parse()
+
+	return &Template{content: content, nodes: nodes, mode: p.mode}
+}
+
+// String returns the template content
+func (t *Template) String() string {
+	return t.content
+}
+
+// Variables returns the variables referenced by the template, including the ones
+// in defaults and alternates, in order of first occurrence
+func (t *Template) Variables() []Variable {
+	variables := []Variable{}
+	seen := map[string]bool{}
+
+	var walk func(nodes []node, optional bool)
+	walk = func(nodes []node, optional bool) {
+		for _, n := range nodes {
+			variable, ok := n.(*templateVariable)
+			if !ok {
+				continue
+			}
+
+			if !seen[variable.name] {
+				seen[variable.name] = true
+
+				v := Variable{
+					Name:       variable.name,
+					IsArray:    variable.isArray,
+					IsOptional: optional || variable.isOptional,
+				}
+
+				if variable.isArray {
+					v.Separator = variable.separator()
+				}
+
+				variables = append(variables, v)
 			}
-		}
 
-		t.variables = append(t.variables, variable)
+			walk(variable.word, optional || variable.isOptional)
+		}
 	}
+
+	walk(t.nodes, false)
+
+	return variables
+}
+
+// Options customizes the expansion of a template
+type Options struct {
+	// Replace returns the text rendered in place of each variable value, or each element
+	// of array variables, if not nil
+	Replace func(value string) string
+	// Join, if not nil, renders array variables in place with their elements joined by it,
+	// instead of expanding the content once for each element
+	Join func(elements []string) string
+	// KeepMissing keeps the placeholders of variables without value as text instead of failing
+	KeepMissing bool
 }
 
-func (t *template) expand(values map[string]string) ([]string, error) {
-	content := t.content
-	arrayValues := map[int]map[*templateVariable][]string{}
+// Expand expands values into the template, see the Expand function
+func (t *Template) Expand(values map[string]string) ([]string, error) {
+	return t.expand(values, Options{})
+}
 
-	t.parse()
+// ExpandWith expands values into the template using options
+func (t *Template) ExpandWith(values map[string]string, options Options) ([]string, error) {
+	return t.expand(values, options)
+}
 
-	for i, variable := range t.variables {
-		// Check if contains the required variable value
-		if _, ok := values[variable.name]; !ok {
-			return nil, &VariableExpandError{Name: variable.name, IsOptional: variable.isOptional}
+func (t *Template) expand(values map[string]string, options Options) ([]string, error) {
+	arrays := []*arrayValues{}
+	scalars := map[*templateVariable]string{}
+
+	replace := options.Replace
+	if replace == nil {
+		replace = func(value string) string { return value }
+	}
+
+	for _, n := range t.nodes {
+		variable, ok := n.(*templateVariable)
+		if !ok {
+			continue
 		}
 
-		// Expand content
-		if !variable.isArray {
-			content = variable.expanded(content, values[variable.name])
-		} else {
-			if arrayValues[i] == nil {
-				arrayValues[i] = make(map[*templateVariable][]string)
+		value, err := variable.value(values)
+		if err != nil {
+			if _, ok := err.(*VariableExpandError); ok && options.KeepMissing {
+				scalars[variable] = t.content[variable.position:variable.end]
+				continue
 			}
 
-			// Set default separator
-			separator := variable.arraySeparator
-			if separator == "" {
-				separator = " "
-			}
+			return nil, err
+		}
 
-			arrayValues[i][variable] = strings.Split(values[variable.name], separator)
+		if !variable.isArray {
+			scalars[variable] = replace(applyPipes(variable.pipes, value))
+			continue
+		}
+
+		elements := strings.Split(value, variable.separator())
+		for i, element := range elements {
+			elements[i] = replace(applyPipes(variable.pipes, element))
+		}
+
+		if options.Join != nil {
+			scalars[variable] = options.Join(elements)
+			continue
 		}
+
+		arrays = append(arrays, &arrayValues{
+			variable: variable,
+			values:   elements,
+		})
 	}
 
 	// Returns the expanded template content if there is no array inside it
-	if len(arrayValues) == 0 {
-		return []string{content}, nil
+	if len(arrays) == 0 {
+		return []string{render(t.nodes, scalars)}, nil
 	}
 
-	return t.expandArrays(content, arrayValues)
+	return t.expandArrays(scalars, arrays)
 }
 
-func (t *template) expandArrays(content string, arrayValues map[int]map[*templateVariable][]string) ([]string, error) {
-	arrayItems := map[int][]*arrayItem{}
-
-	length := -1
+// expandArrays expands the content once for each array element, in the order of the
+// elements, in zip mode all arrays are expanded together so they must have the same size,
+// in product mode each array is expanded for every element of the arrays before it
+func (t *Template) expandArrays(scalars map[*templateVariable]string, arrays []*arrayValues) ([]string, error) {
+	groups := [][]*arrayValues{arrays}
 
-	for _, variables := range arrayValues {
-		for variable, values := range variables {
-			if length > -1 && length != len(values) {
-				return nil, fmt.Errorf("Array size differs: %s (%d should be %d)", variable.name, len(values), length)
-			}
+	if t.mode == modeProduct {
+		groups = groupArrays(arrays)
+	}
 
-			length = len(values)
+	total := 1
 
-			for index, value := range values {
-				item := &arrayItem{
-					variable: variable,
-					value:    value,
-					index:    index,
-				}
+	for _, group := range groups {
+		length := len(group[0].values)
 
-				arrayItems[index] = append(arrayItems[index], item)
+		for _, array := range group[1:] {
+			if len(array.values) != length {
+				return nil, fmt.Errorf("Array size differs: %s (%d should be %d)", array.variable.name, len(array.values), length)
 			}
 		}
+
+		total *= length
+
+		if total > MaxExpansions {
+			return nil, fmt.Errorf("Too many expansions: more than %d", MaxExpansions)
+		}
 	}
 
 	expandedArrays := []string{}
 
-	for _, items := range arrayItems {
-		expandedItem := content
+	// indexes holds the current element index of each group, the last group changes fastest
+	indexes := make([]int, len(groups))
 
-		for _, item := range items {
-			expandedItem = item.variable.expanded(expandedItem, item.value)
+	for n := 0; n < total; n++ {
+		for i, group := range groups {
+			for _, array := range group {
+				scalars[array.variable] = array.values[indexes[i]]
+			}
 		}
 
-		expandedArrays = append(expandedArrays, expandedItem)
+		expandedArrays = append(expandedArrays, render(t.nodes, scalars))
+
+		for i := len(groups) - 1; i >= 0; i-- {
+			indexes[i]++
+			if indexes[i] < len(groups[i][0].values) {
+				break
+			}
+
+			indexes[i] = 0
+		}
 	}
 
 	return expandedArrays, nil
 }
 
-func (t *template) match() []map[string]string {
-	result := []map[string]string{}
-	re := regexp.MustCompile(templateRegexp)
-	groups := re.SubexpNames()[1:]
-	matches := re.FindAllStringSubmatch(t.content, -1)
+// groupArrays groups the occurrences of the same array variable, in order of first occurrence,
+// so they are expanded together in product mode
+func groupArrays(arrays []*arrayValues) [][]*arrayValues {
+	groups := [][]*arrayValues{}
+	index := map[string]int{}
+
+	for _, array := range arrays {
+		key := array.variable.name + "[" + array.variable.arraySeparator + "]"
 
-	if len(matches) == 0 {
-		return result
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+
+		groups[i] = append(groups[i], array)
 	}
 
-	for _, v := range matches {
-		m := map[string]string{}
+	return groups
+}
 
-		for i, group := range groups {
-			m[group] = v[i+1]
-		}
+// render concatenates the text nodes and the variable values
+func render(nodes []node, values map[*templateVariable]string) string {
+	buf := bytes.Buffer{}
 
-		result = append(result, m)
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case string:
+			buf.WriteString(n)
+		case *templateVariable:
+			buf.WriteString(values[n])
+		}
 	}
 
-	return result
+	return buf.String()
 }
 
 // Expand expands values into template content
+//
+// Templates with array variables expand to one string per array element. By default
+// arrays are zipped and must have the same size, templates starting with {@product}
+// expand to every combination of the elements instead. Expand fails if a template
+// expands to more than MaxExpansions strings.
 func Expand(content string, values map[string]string) ([]string, error) {
-	tpl := &template{
-		content: content,
-	}
+	return compile(content).Expand(values)
+}
+
+// ExpandWith expands values into template content using options, see Expand
+func ExpandWith(content string, values map[string]string, options Options) ([]string, error) {
+	return compile(content).ExpandWith(values, options)
+}
+
+// Variables returns the variables referenced by content, ignoring malformed placeholders,
+// see Template.Variables
+func Variables(content string) []Variable {
+	return compile(content).Variables()
+}
+
+// Escape escapes the braces of text so it expands to itself
+func Escape(text string) string {
+	return strings.Replace(text, "{", "{{", -1)
+}
+
+// Validate checks the syntax of the variable placeholders of content
+//
+// Expand keeps malformed placeholders as text, Validate reports them instead.
+// Named topic captures like {+name} are not placeholders.
+func Validate(content string) error {
+	_, err := Compile(content)
 
-	return tpl.expand(values)
+	return err
 }
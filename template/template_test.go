@@ -2,7 +2,7 @@ package template
 
 import (
 	"errors"
-	"sort"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -127,6 +127,14 @@ func TestExpandArray(t *testing.T) {
 			[]string{"VARIABLE"},
 			nil,
 		},
+
+		{
+			"KeepsElementOrder",
+			"devices/{ARRAY[]}",
+			map[string]string{"ARRAY": "c a d b"},
+			[]string{"devices/c", "devices/a", "devices/d", "devices/b"},
+			nil,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -139,9 +147,639 @@ func TestExpandArray(t *testing.T) {
 				assert.NoError(t, err)
 			}
 
-			sort.Strings(list)
+			assert.Equal(t, tc.expectedResult, list)
+		})
+	}
+}
+
+func TestExpandMultipleArrays(t *testing.T) {
+	testCases := []struct {
+		name           string
+		content        string
+		env            map[string]string
+		expectedResult []string
+		expectedError  error
+	}{
+		{
+			"TwoArrays",
+			"{NAMES[]}/{IDS[,]}",
+			map[string]string{"NAMES": "b a c", "IDS": "2,1,3"},
+			[]string{"b/2", "a/1", "c/3"},
+			nil,
+		},
+
+		{
+			"ArraysWithVariable",
+			"{PREFIX}/{NAMES[]}/{IDS[]}",
+			map[string]string{"PREFIX": "devices", "NAMES": "z y", "IDS": "9 8"},
+			[]string{"devices/z/9", "devices/y/8"},
+			nil,
+		},
+
+		{
+			"SameArrayTwice",
+			"{NAMES[]}/{NAMES[]}",
+			map[string]string{"NAMES": "b a"},
+			[]string{"b/b", "a/a"},
+			nil,
+		},
+
+		{
+			"SecondArraySmaller",
+			"{ARRAY1[]}/{ARRAY2[]}",
+			map[string]string{"ARRAY1": "a b c", "ARRAY2": "1 2"},
+			nil,
+			errors.New("Array size differs: ARRAY2 (2 should be 3)"),
+		},
+
+		{
+			"SecondArrayBigger",
+			"{ARRAY1[]}/{ARRAY2[]}",
+			map[string]string{"ARRAY1": "a", "ARRAY2": "1 2"},
+			nil,
+			errors.New("Array size differs: ARRAY2 (2 should be 1)"),
+		},
+
+		{
+			"MissingSecondArray",
+			"{ARRAY1[]}/{ARRAY2[]}",
+			map[string]string{"ARRAY1": "a b"},
+			nil,
+			errors.New("No value for required variable"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Expand repeatedly, the order must not change between runs
+			for i := 0; i < 10; i++ {
+				list, err := Expand(tc.content, tc.env)
+
+				if tc.expectedError != nil {
+					assert.EqualError(t, err, tc.expectedError.Error())
+				} else {
+					assert.NoError(t, err)
+				}
+
+				assert.Equal(t, tc.expectedResult, list)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		content       string
+		expectedError error
+	}{
+		{
+			"Variables",
+			"devices/{DEVICE}/{ARRAY[]}/{LIST[,]}/{OPTIONAL}?",
+			nil,
+		},
+
+		{
+			"Literals",
+			`{"key": "value"} {+device} {#path} {}`,
+			nil,
+		},
+
+		{
+			"ShortName",
+			"devices/{D}",
+			errors.New("template syntax error at position 8: variable name must have at least two characters: D"),
+		},
+
+		{
+			"InvalidArraySeparator",
+			"{ARRAY[,,]}",
+			errors.New("template syntax error at position 0: invalid array separator"),
+		},
+
+		{
+			"UnterminatedArray",
+			"{ARRAY[]",
+			errors.New("template syntax error at position 0: unterminated variable placeholder"),
+		},
+
+		{
+			"Unterminated",
+			"devices/{DEVICE",
+			errors.New("template syntax error at position 8: unterminated variable placeholder"),
+		},
+
+		{
+			"ProductDirective",
+			"{@product}{REGION[,]}/{SENSOR[,]}",
+			nil,
+		},
+
+		{
+			"UnknownDirective",
+			"{@sum}{REGION[,]}",
+			errors.New("template syntax error at position 0: unknown directive: @sum"),
+		},
+
+		{
+			"UnterminatedDirective",
+			"{@product",
+			errors.New("template syntax error at position 0: unterminated directive"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.content)
+
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestExpandDefault(t *testing.T) {
+	testCases := []struct {
+		name           string
+		content        string
+		env            map[string]string
+		expectedResult []string
+		expectedError  error
+	}{
+		{
+			"DefaultNotUsed",
+			"{DEVICE:-unknown}",
+			map[string]string{"DEVICE": "device1"},
+			[]string{"device1"},
+			nil,
+		},
+
+		{
+			"DefaultUnset",
+			"devices/{DEVICE:-unknown}",
+			map[string]string{},
+			[]string{"devices/unknown"},
+			nil,
+		},
+
+		{
+			"DefaultEmpty",
+			"devices/{DEVICE:-unknown}",
+			map[string]string{"DEVICE": ""},
+			[]string{"devices/unknown"},
+			nil,
+		},
+
+		{
+			"DefaultWithVariable",
+			"{DEVICE:-{HOSTNAME}}",
+			map[string]string{"HOSTNAME": "host1"},
+			[]string{"host1"},
+			nil,
+		},
+
+		{
+			"DefaultWithMissingVariable",
+			"a/{DEVICE:-{HOSTNAME}}",
+			map[string]string{},
+			nil,
+			errors.New("No value for required variable"),
+		},
+
+		{
+			"AlternateSet",
+			"devices{REGION:+/region/{REGION}}/status",
+			map[string]string{"REGION": "eu"},
+			[]string{"devices/region/eu/status"},
+			nil,
+		},
+
+		{
+			"AlternateUnset",
+			"devices{REGION:+/region/{REGION}}/status",
+			map[string]string{},
+			[]string{"devices/status"},
+			nil,
+		},
+
+		{
+			"ArrayDefault",
+			"{DEVICES[,]:-a,b}",
+			map[string]string{},
+			[]string{"a", "b"},
+			nil,
+		},
+
+		{
+			"ValueWithDollar",
+			"{PRICE}",
+			map[string]string{"PRICE": "$1"},
+			[]string{"$1"},
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := Expand(tc.content, tc.env)
+
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
 
 			assert.Equal(t, tc.expectedResult, list)
 		})
 	}
 }
+
+func TestVariableExpandErrorPosition(t *testing.T) {
+	testCases := []struct {
+		name             string
+		content          string
+		expectedName     string
+		expectedPosition int
+		expectedOptional bool
+	}{
+		{"Variable", "devices/{DEVICE}", "DEVICE", 8, false},
+		{"SecondVariable", "{A1}/{B1}?", "B1", 5, true},
+		{"NestedVariable", "a/{DEVICE:-{HOSTNAME}}", "HOSTNAME", 11, false},
+		{"NestedOptional", "a/{DEVICE:-{HOSTNAME}}?", "HOSTNAME", 11, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Expand(tc.content, map[string]string{"A1": "a"})
+
+			ve, ok := err.(*VariableExpandError)
+			if assert.True(t, ok) {
+				assert.Equal(t, tc.expectedName, ve.Name)
+				assert.Equal(t, tc.expectedPosition, ve.Position)
+				assert.Equal(t, tc.expectedOptional, ve.IsOptional)
+			}
+		})
+	}
+}
+
+func TestExpandFunctions(t *testing.T) {
+	testCases := []struct {
+		name           string
+		content        string
+		env            map[string]string
+		expectedResult []string
+	}{
+		{"Lower", "devices/{DEVICE|lower}", map[string]string{"DEVICE": "Device-A1"}, []string{"devices/device-a1"}},
+		{"Upper", "{DEVICE|upper}", map[string]string{"DEVICE": "Device-A1"}, []string{"DEVICE-A1"}},
+		{"Trim", "{SERIAL|trim}", map[string]string{"SERIAL": " 1234\n"}, []string{"1234"}},
+		{"Replace", `{MAC|replace(":","")}`, map[string]string{"MAC": "AA:BB:CC"}, []string{"AABBCC"}},
+		{"Chain", "{ID|sha256|prefix(8)}", map[string]string{"ID": "device"}, []string{"263a4dbe"}},
+		{"PrefixLongerThanValue", "{ID|prefix(8)}", map[string]string{"ID": "abc"}, []string{"abc"}},
+		{"URLEncode", "files/{PATH|urlencode}", map[string]string{"PATH": "/tmp/a b+c"}, []string{"files/%2Ftmp%2Fa%20b%2Bc"}},
+		{"Array", "{DEVICES[,]|trim|upper}", map[string]string{"DEVICES": "a, b ,c"}, []string{"A", "B", "C"}},
+		{"Default", "{DEVICE|upper:-unknown}", map[string]string{}, []string{"UNKNOWN"}},
+		{"NestedInAlternate", "a{REGION:+/{REGION|lower}}", map[string]string{"REGION": "EU"}, []string{"a/eu"}},
+		{"UnknownFunctionKeptAsText", "{DEVICE|reverse}", map[string]string{"DEVICE": "a"}, []string{"{DEVICE|reverse}"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := Expand(tc.content, tc.env)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedResult, list)
+		})
+	}
+}
+
+func TestExpandProduct(t *testing.T) {
+	testCases := []struct {
+		name           string
+		content        string
+		env            map[string]string
+		expectedResult []string
+		expectedError  error
+	}{
+		{
+			"ZipByDefault",
+			"{REGION[,]}/{SENSOR[,]}",
+			map[string]string{"REGION": "eu,us", "SENSOR": "temp,hum"},
+			[]string{"eu/temp", "us/hum"},
+			nil,
+		},
+
+		{
+			"ExplicitZip",
+			"{@zip}{REGION[,]}/{SENSOR[,]}",
+			map[string]string{"REGION": "eu,us", "SENSOR": "temp"},
+			nil,
+			errors.New("Array size differs: SENSOR (1 should be 2)"),
+		},
+
+		{
+			"Product",
+			"{@product}{REGION[,]}/{SENSOR[,]}",
+			map[string]string{"REGION": "eu,us", "SENSOR": "temp,hum,co2"},
+			[]string{"eu/temp", "eu/hum", "eu/co2", "us/temp", "us/hum", "us/co2"},
+			nil,
+		},
+
+		{
+			"ProductWithScalar",
+			"{@product}{DEVICE}/{REGION[,]}/{SENSOR[]}",
+			map[string]string{"DEVICE": "d1", "REGION": "eu", "SENSOR": "temp hum"},
+			[]string{"d1/eu/temp", "d1/eu/hum"},
+			nil,
+		},
+
+		{
+			"ProductRepeatedVariable",
+			"{@product}{REGION[,]}/{SENSOR[,]}/{REGION[,]|upper}",
+			map[string]string{"REGION": "eu,us", "SENSOR": "temp,hum"},
+			[]string{"eu/temp/EU", "eu/hum/EU", "us/temp/US", "us/hum/US"},
+			nil,
+		},
+
+		{
+			"UnknownDirectiveKeptAsText",
+			"{@sum}{REGION[,]}",
+			map[string]string{"REGION": "eu,us"},
+			[]string{"{@sum}eu", "{@sum}us"},
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := Expand(tc.content, tc.env)
+
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expectedResult, list)
+		})
+	}
+}
+
+func TestExpandMaxExpansions(t *testing.T) {
+	defer func(max int) { MaxExpansions = max }(MaxExpansions)
+
+	MaxExpansions = 4
+
+	env := map[string]string{"A1": "1,2,3", "B1": "1,2"}
+
+	list, err := Expand("{@product}{A1[,]}/{B1[,]}", env)
+	assert.EqualError(t, err, "Too many expansions: more than 4")
+	assert.Nil(t, list)
+
+	list, err = Expand("{A1[,]}", map[string]string{"A1": "1,2,3,4,5"})
+	assert.EqualError(t, err, "Too many expansions: more than 4")
+	assert.Nil(t, list)
+
+	list, err = Expand("{@product}{B1[,]}/{B1[,]}/{A1[,]|prefix(0)}", map[string]string{"A1": "1", "B1": "1,2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1/1/", "2/2/"}, list)
+}
+
+func TestCompile(t *testing.T) {
+	testCases := []struct {
+		name              string
+		content           string
+		expectedVariables []Variable
+		expectedError     error
+	}{
+		{
+			"NoVariables",
+			"devices/status",
+			[]Variable{},
+			nil,
+		},
+
+		{
+			"Variables",
+			"{DEVICE}/{SENSORS[]}/{REGIONS[,]}/{ZONE}?",
+			[]Variable{
+				{Name: "DEVICE"},
+				{Name: "SENSORS", IsArray: true, Separator: " "},
+				{Name: "REGIONS", IsArray: true, Separator: ","},
+				{Name: "ZONE", IsOptional: true},
+			},
+			nil,
+		},
+
+		{
+			"NestedVariables",
+			"{DEVICE:-{HOSTNAME}}/{REGION:+{REGION}/{ZONE}}?",
+			[]Variable{
+				{Name: "DEVICE"},
+				{Name: "HOSTNAME"},
+				{Name: "REGION", IsOptional: true},
+				{Name: "ZONE", IsOptional: true},
+			},
+			nil,
+		},
+
+		{
+			"RepeatedVariable",
+			"{DEVICE}/{DEVICE|lower}",
+			[]Variable{{Name: "DEVICE"}},
+			nil,
+		},
+
+		{
+			"SyntaxError",
+			"devices/{DEVICE",
+			nil,
+			errors.New("template syntax error at position 8: unterminated variable placeholder"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tpl, err := Compile(tc.content)
+
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+				assert.Nil(t, tpl)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.content, tpl.String())
+			assert.Equal(t, tc.expectedVariables, tpl.Variables())
+		})
+	}
+}
+
+func TestTemplateExpand(t *testing.T) {
+	tpl, err := Compile("devices/{DEVICE|lower}/{SENSORS[,]}")
+	assert.NoError(t, err)
+
+	list, err := tpl.Expand(map[string]string{"DEVICE": "A1", "SENSORS": "temp,hum"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"devices/a1/temp", "devices/a1/hum"}, list)
+
+	list, err = tpl.Expand(map[string]string{"DEVICE": "B1", "SENSORS": "co2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"devices/b1/co2"}, list)
+
+	list, err = tpl.Expand(map[string]string{"SENSORS": "co2"})
+	assert.EqualError(t, err, "No value for required variable")
+	assert.Nil(t, list)
+}
+
+func TestExpandWith(t *testing.T) {
+	quote := func(value string) string { return "'" + value + "'" }
+
+	testCases := []struct {
+		name           string
+		content        string
+		env            map[string]string
+		options        Options
+		expectedResult []string
+		expectedError  error
+	}{
+		{
+			"Replace",
+			"echo {DEVICE|lower} {SENSORS[,]}",
+			map[string]string{"DEVICE": "A1", "SENSORS": "temp,hum"},
+			Options{Replace: quote},
+			[]string{"echo 'a1' 'temp'", "echo 'a1' 'hum'"},
+			nil,
+		},
+
+		{
+			"ReplaceDefault",
+			"echo {DEVICE:-unknown device}",
+			map[string]string{},
+			Options{Replace: quote},
+			[]string{"echo 'unknown device'"},
+			nil,
+		},
+
+		{
+			"KeepMissing",
+			"echo {DEVICE} {MISSING} {OTHER[,]}? {MISSING:-{OTHER}}",
+			map[string]string{"DEVICE": "a1"},
+			Options{Replace: quote, KeepMissing: true},
+			[]string{"echo 'a1' {MISSING} {OTHER[,]}? {MISSING:-{OTHER}}"},
+			nil,
+		},
+
+		{
+			"MissingWithoutKeepMissing",
+			"echo {DEVICE} {MISSING}",
+			map[string]string{"DEVICE": "a1"},
+			Options{Replace: quote},
+			nil,
+			errors.New("No value for required variable"),
+		},
+
+		{
+			"Join",
+			"echo {DEVICE} {SENSORS[,]|upper}",
+			map[string]string{"DEVICE": "a1", "SENSORS": "temp,hum"},
+			Options{Replace: quote, Join: func(elements []string) string { return strings.Join(elements, " ") }},
+			[]string{"echo 'a1' 'TEMP' 'HUM'"},
+			nil,
+		},
+
+		{
+			"DottedName",
+			"{payload.device.id}/{payload.items.0}",
+			map[string]string{"payload.device.id": "a1", "payload.items.0": "x"},
+			Options{},
+			[]string{"a1/x"},
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := ExpandWith(tc.content, tc.env, tc.options)
+
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expectedResult, list)
+		})
+	}
+}
+
+func TestExpandMalformed(t *testing.T) {
+	testCases := []struct {
+		name           string
+		content        string
+		values         map[string]string
+		expectedResult []string
+		expectedError  error
+	}{
+		{
+			"UnknownFunction",
+			"{DEVICE|nosuch}/{MODEL}",
+			map[string]string{"DEVICE": "dev", "MODEL": "a"},
+			[]string{"{DEVICE|nosuch}/a"},
+			errors.New("template syntax error at position 0: unknown function: nosuch"),
+		},
+
+		{
+			"ArrayInDefault",
+			"{DEVICE:-{ARRAY[]}}",
+			map[string]string{"ARRAY": "x y"},
+			[]string{"{DEVICE:-{ARRAY[]}}"},
+			errors.New("template syntax error at position 9: array variables are not allowed in defaults and alternates"),
+		},
+
+		{
+			"MalformedInDefault",
+			"{DEVICE:-{MODEL|nosuch}}",
+			map[string]string{"MODEL": "a"},
+			[]string{"{MODEL|nosuch}"},
+			errors.New("template syntax error at position 9: unknown function: nosuch"),
+		},
+
+		{
+			"ShortName",
+			"{D}/{MODEL}",
+			map[string]string{"D": "dev", "MODEL": "a"},
+			[]string{"{D}/a"},
+			errors.New("template syntax error at position 0: variable name must have at least two characters: D"),
+		},
+
+		{
+			"Unterminated",
+			"{DEVICE[]/{MODEL}",
+			map[string]string{"DEVICE": "dev", "MODEL": "a"},
+			[]string{"{DEVICE[]/a"},
+			errors.New("template syntax error at position 0: unterminated variable placeholder"),
+		},
+
+		{
+			"EscapedBrace",
+			"{DEVICE|nosuch:-{{x}/{MODEL}",
+			map[string]string{"MODEL": "a"},
+			[]string{"{DEVICE|nosuch:-{x}/a"},
+			errors.New("template syntax error at position 0: unknown function: nosuch"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := Expand(tc.content, tc.values)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedResult, result)
+
+			_, err = Compile(tc.content)
+			assert.EqualError(t, err, tc.expectedError.Error())
+		})
+	}
+}
@@ -1,31 +1,83 @@
 package template
 
-import (
-	"fmt"
-	"regexp"
-)
-
 type templateVariable struct {
+	// position and end are the byte offsets of the placeholder in the template
+	position       int
+	end            int
 	name           string
 	isArray        bool
 	arraySeparator string
 	isOptional     bool
+	// modifier is '-' for a default and '+' for an alternate, word holds its template
+	modifier byte
+	word     []node
+	// pipes are the functions applied to the value
+	pipes []*pipe
 }
 
-func (v *templateVariable) expanded(content string, value string) string {
-	re := regexp.MustCompile(regexp.QuoteMeta(v.string()))
-	return re.ReplaceAllString(content, value)
+// separator returns the array separator, a space by default
+func (v *templateVariable) separator() string {
+	if v.arraySeparator == "" {
+		return " "
+	}
+
+	return v.arraySeparator
 }
 
-func (v *templateVariable) string() string {
-	array := map[bool]string{true: "[" + v.arraySeparator + "]", false: ""}
-	optional := map[bool]string{true: "?", false: ""}
+// value returns the variable value, applying the default or alternate
+func (v *templateVariable) value(values map[string]string) (string, error) {
+	value, ok := values[v.name]
+
+	switch v.modifier {
+	case '-':
+		if ok && value != "" {
+			return value, nil
+		}
+
+		return v.expandWord(values)
+	case '+':
+		if ok && value != "" {
+			return v.expandWord(values)
+		}
+
+		return "", nil
+	}
+
+	if !ok {
+		return "", &VariableExpandError{Name: v.name, IsOptional: v.isOptional, Position: v.position}
+	}
+
+	return value, nil
+}
+
+// expandWord expands the default or alternate of the variable
+func (v *templateVariable) expandWord(values map[string]string) (string, error) {
+	resolved := map[*templateVariable]string{}
+
+	for _, n := range v.word {
+		variable, ok := n.(*templateVariable)
+		if !ok {
+			continue
+		}
+
+		value, err := variable.value(values)
+		if err != nil {
+			// A missing variable is optional if the enclosing one is
+			if ve, ok := err.(*VariableExpandError); ok && v.isOptional {
+				ve.IsOptional = true
+			}
+
+			return "", err
+		}
+
+		resolved[variable] = applyPipes(variable.pipes, value)
+	}
 
-	return fmt.Sprintf("{%s%s}%s", v.name, array[v.isArray], optional[v.isOptional])
+	return render(v.word, resolved), nil
 }
 
-type arrayItem struct {
+// arrayValues holds the values of an array variable in the order of the source value
+type arrayValues struct {
 	variable *templateVariable
-	value    string
-	index    int
+	values   []string
 }